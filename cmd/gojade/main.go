@@ -0,0 +1,107 @@
+// The gojade command generates Go source from jade templates.
+//
+//	gojade generate [flags] template...
+//
+// Each template is compiled with its extends chain, includes and mixins, and written to
+// a file named after the template, index.jade is generated as index_jade.go with a
+// RenderIndex(wr io.Writer, data *Page) error function. The data type is found in the
+// package the files is generated in, or in a package imported with -import.
+// Example: //go:generate gojade generate -views views -type *models.Page -import example.com/app/models index.jade
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zdebeer99/gojade"
+	"github.com/zdebeer99/gojade/jadeparser"
+)
+
+// importList is a flag that can be set more than once.
+type importList []string
+
+func (this *importList) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *importList) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "generate" {
+		fmt.Fprintln(os.Stderr, "usage: gojade generate [flags] template...")
+		os.Exit(2)
+	}
+	if err := generate(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	views := flags.String("views", ".", "directory the templates is loaded from")
+	out := flags.String("o", ".", "directory the generated files is written to")
+	pkg := flags.String("package", "", "package name of the generated files. Default: the package in -o, or views")
+	datatype := flags.String("type", "interface{}", "type of the data parameter, Example: *models.Page")
+	fn := flags.String("func", "", "name of the render function, only valid with a single template. Default: Render followed by the template name")
+	var imports importList
+	flags.Var(&imports, "import", "package of the -type, can be repeated. Example: example.com/app/models")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gojade generate [flags] template...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if len(*fn) > 0 && flags.NArg() > 1 {
+		return fmt.Errorf("-func is only valid with a single template.")
+	}
+	var dataType types.Type
+	var dataPkg *types.Package
+	if *datatype != "interface{}" {
+		var err error
+		dataType, dataPkg, err = loadType(*out, *pkg, *datatype, imports)
+		if err != nil {
+			return err
+		}
+	}
+	jade := gojade.New()
+	jade.ViewPath = *views
+	for _, name := range flags.Args() {
+		template, err := jade.Compile(name)
+		if err != nil {
+			return err
+		}
+		source, err := template.Generate(jadeparser.GenerateOptions{
+			Package: *pkg,
+			Func:    *fn,
+			Type:    dataType,
+			Pkg:     dataPkg,
+		})
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(*out, outputName(name))
+		if err := ioutil.WriteFile(filename, source, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputName returns the name of the file generated for a template. Example: user/edit.jade returns user_edit_jade.go
+func outputName(name string) string {
+	name = strings.TrimSuffix(filepath.ToSlash(name), ".jade")
+	name = strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(name)
+	return name + "_jade.go"
+}
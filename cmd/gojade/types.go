@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dataFile is the file declaring a variable of the data type, checked with the package.
+const dataFile = "gojade_data.go"
+
+// loadType returns the type of the data parameter and the package of the generated files.
+// The type is found with go/types in the package in dir and the packages in imports. Files
+// generated by gojade is skipped, so a type changed since the files was generated is found.
+func loadType(dir string, pkgname string, typeexpr string, imports []string) (types.Type, *types.Package, error) {
+	if len(pkgname) == 0 {
+		pkgname = "views"
+	}
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0)
+	pkgpath := pkgname
+	if bp, err := build.ImportDir(dir, 0); err == nil {
+		for _, name := range bp.GoFiles {
+			file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !isGenerated(file) {
+				files = append(files, file)
+			}
+		}
+		if len(files) > 0 {
+			pkgname = bp.Name
+		}
+		if bp.ImportPath != "." {
+			pkgpath = bp.ImportPath
+		}
+	}
+	src := new(bytes.Buffer)
+	fmt.Fprintf(src, "package %s\n\nimport (\n", pkgname)
+	for _, imp := range imports {
+		fmt.Fprintf(src, "\t%q\n", imp)
+	}
+	fmt.Fprintf(src, ")\n\nvar gojadeData %s\n", typeexpr)
+	file, err := parser.ParseFile(fset, filepath.Join(dir, dataFile), src.Bytes(), 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid -type %s. %v", typeexpr, err)
+	}
+	files = append(files, file)
+
+	exports := &exportData{dir: dir, files: make(map[string]string)}
+	if err := exports.load(importPaths(files)); err != nil {
+		return nil, nil, err
+	}
+	//errors in the package is ignored, only the data type must be valid.
+	var typeErr error
+	conf := types.Config{
+		Importer:    importer.ForCompiler(fset, "gc", exports.lookup),
+		FakeImportC: true,
+		Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if ok && !terr.Soft && filepath.Base(fset.Position(terr.Pos).Filename) == dataFile && typeErr == nil {
+				typeErr = err
+			}
+		},
+	}
+	pkg, _ := conf.Check(pkgpath, fset, files, nil)
+	if typeErr != nil {
+		return nil, nil, fmt.Errorf("Invalid -type %s. %v", typeexpr, typeErr)
+	}
+	return pkg.Scope().Lookup("gojadeData").Type(), pkg, nil
+}
+
+// isGenerated reports if a file is generated by gojade.
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		if strings.HasPrefix(group.Text(), "Code generated by gojade") {
+			return true
+		}
+	}
+	return false
+}
+
+// importPaths returns the packages imported by files.
+func importPaths(files []*ast.File) []string {
+	paths := make([]string, 0)
+	for _, file := range files {
+		for _, imp := range file.Imports {
+			if path, err := strconv.Unquote(imp.Path.Value); err == nil && path != "C" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// exportData finds the compiled export data of packages with go list, the export data
+// contains the types of the package read by the gc importer.
+type exportData struct {
+	dir   string
+	files map[string]string //Import path to export data file.
+}
+
+func (this *exportData) load(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	cmd := exec.Command("go", append([]string{"list", "-e", "-export", "-f", "{{.ImportPath}}={{.Export}}"}, paths...)...)
+	if _, err := os.Stat(this.dir); err == nil {
+		cmd.Dir = this.dir
+	}
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go list: %v %s", err, stderr.String())
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, "="); i > 0 {
+			this.files[line[:i]] = line[i+1:]
+		}
+	}
+	return nil
+}
+
+func (this *exportData) lookup(path string) (io.ReadCloser, error) {
+	if _, ok := this.files[path]; !ok {
+		if err := this.load([]string{path}); err != nil {
+			return nil, err
+		}
+	}
+	if len(this.files[path]) == 0 {
+		return nil, fmt.Errorf("Package %q not found, or has errors.", path)
+	}
+	return os.Open(this.files[path])
+}
@@ -22,7 +22,10 @@ func index(rw http.ResponseWriter, req *http.Request) {
 	//init model data
 	data := &pageModel{"GoJade http Example", "Glen Lovelace", 32, []string{"Joe", "Marco", "Mimi"}}
 	//render jade page. GoJade will automatically append .jade to a file name if no file extension is specified.
-	jade.RenderFileW(rw, "index", data)
+	err := jade.RenderFileW(rw, "index", data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func main() {
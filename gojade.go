@@ -2,50 +2,459 @@ package gojade
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/zdebeer99/gojade/jadeparser"
 	"io"
+	"io/fs"
 	"reflect"
+	"sync"
 )
 
 // Engine keeps configuration information and redirect calls to the jadeparser.
+// Compiled templates are cached by the engine, an Engine can be shared between goroutines.
 type Engine struct {
 	ViewPath string
+	//ViewPaths is a ordered list of view paths, used instead of ViewPath when set.
+	//A template is loaded from the first view path containing the file, allowing a
+	//view path to override some templates of the view paths after it.
+	//Use 'extends ^layout.jade' to extend the layout.jade in the next view path.
+	ViewPaths []string
+	//FS when set, templates are loaded from the ViewPath inside FS instead of from disk.
+	//Example: jade.FS = embedFS
+	FS       fs.FS
 	Beautify bool
-	extfunc  map[string]reflect.Value
+	//Reload when true, the files a cached template depends on is checked for changes
+	//every time the template is used, and the template is recompiled when a file changed.
+	//Use during development, when false cached templates is used without checking the files.
+	Reload bool
+	//Strict when true, using a undefined variable or a member of a nil value is an error,
+	//instead of rendering an empty string. Example: p= user.Nmae fails with Variable "user.Nmae" not defined.
+	Strict bool
+	//Globals is variables available to every template, like the site name or build version.
+	//A variable on the data passed to the render methods shadows a global with the same name.
+	//Use SetGlobal to change globals while templates is rendered.
+	Globals map[string]interface{}
+	//Limits restricts the resources a template can use while rendering, zero values means no limit,
+	//except MaxDepth that is jadeparser.DefaultMaxDepth when zero.
+	//Errors for exceeded limits wraps jadeparser.ErrLimitExceeded.
+	//Example: jade.Limits = jadeparser.Limits{MaxDepth: 50, MaxIterations: 10000, MaxOutput: 1 << 20}
+	Limits jadeparser.Limits
+	//Sandbox restricts the registered functions, methods and fields templates can use.
+	//Errors for restricted members wraps jadeparser.ErrNotAllowed.
+	Sandbox *jadeparser.Sandbox
+	//FlushTags is the tags the output is flushed after, when the tag is closed. Output is
+	//buffered while rendering, when the writer is a http.Flusher the buffered output is
+	//sent to the client at the flush points, and at the flush keyword in a template.
+	//Write errors is returned when the buffered output is written to the writer.
+	//Example: jade.FlushTags = []string{"head"}
+	FlushTags []string
+	//Observers is notified before and after each render, include, extends, mixin call and
+	//registered function call, with the name, duration and error of the call.
+	Observers []jadeparser.Observer
+	//WarningHandler is called for each warning found in a template, like undefined variables.
+	//Warnings found while parsing is reported when the template is compiled.
+	WarningHandler func(warning *jadeparser.Warning)
+	extfunc        map[string]reflect.Value
+	sources        *jadeparser.MapLoader //Templates added with AddTemplate.
+	mu             sync.RWMutex
+	templates      map[string]*Template
+	cacheKey       cacheKey //View paths and FS used to compile the cached templates, see loaderKey.
+}
+
+// Template is a compiled jade template returned by Engine.Compile.
+// Execute can be called from multiple goroutines at the same time.
+type Template struct {
+	Name     string
+	engine   *Engine
+	compiled *jadeparser.CompiledTemplate
+}
+
+// RenderOptions overrides engine settings for a single call to Render,
+// without changing the Engine shared with other goroutines.
+type RenderOptions struct {
+	Beautify *bool //Overrides Engine.Beautify when not nil.
+	//Doctype is the doctype used to render tags until the template declares a doctype.
+	//Example: "html" renders self closing tags as <br>
+	Doctype string
+	//Locals is variables available to the template, shadowing the data and globals.
+	Locals map[string]interface{}
+	//Funcs is functions available to the template, found before the registered functions.
+	Funcs map[string]interface{}
 }
 
 // Creates a new instance of the jade instance struct.
 func New() *Engine {
 	gojade := new(Engine)
 	gojade.extfunc = make(map[string]reflect.Value)
+	gojade.templates = make(map[string]*Template)
+	gojade.sources = jadeparser.NewMapLoader()
 	return gojade
 }
 
-// RenderFile Renders a jade file to a bytes.Buffer.
-// Example: jade.RenderFile("index.jade",nil).String()
-func (this *Engine) RenderFile(filename string, data interface{}) *bytes.Buffer {
+// AddTemplate adds a named template kept in memory. Named templates can be
+// rendered, extended and included by name the same as files, and is found before
+// files in the view path.
+// Example: jade.AddTemplate("layout", "html\n  body\n    block content")
+func (this *Engine) AddTemplate(name string, source string) {
+	this.sources.Add(name, source)
+	this.ClearCache()
+}
+
+// Compile parses a jade file and resolves its extends chain, mixins, blocks and
+// includes. The result is cached, calling Compile again with the same name returns
+// the cached template. Names is cached without the .jade extension, "index" and
+// "index.jade" returns the same template.
+func (this *Engine) Compile(name string) (*Template, error) {
+	this.mu.RLock()
+	template, ok := this.templates[templateName(name)]
+	key := this.loaderKey()
+	valid := this.cacheKey == key
+	this.mu.RUnlock()
+	if ok && valid && !(this.Reload && template.compiled.Modified(this.loader())) {
+		return template, nil
+	}
+	compiled, err := jadeparser.Compile(this.loader(), name)
+	if err != nil {
+		return nil, err
+	}
+	if this.WarningHandler != nil {
+		for _, warning := range compiled.Warnings() {
+			this.WarningHandler(warning)
+		}
+	}
+	template = &Template{name, this, compiled}
+	this.mu.Lock()
+	if this.cacheKey != key {
+		this.templates = make(map[string]*Template)
+		this.cacheKey = key
+	}
+	this.templates[templateName(name)] = template
+	this.mu.Unlock()
+	return template, nil
+}
+
+// Dependencies returns the extends chain and included files of a template, and the
+// templates defining each mixin and block.
+func (this *Engine) Dependencies(name string) (*jadeparser.Dependencies, error) {
+	template, err := this.Compile(name)
+	if err != nil {
+		return nil, err
+	}
+	return template.compiled.Dependencies(), nil
+}
+
+// Dependents returns the templates in the view paths depending on a file, directly
+// or through other templates. Templates failing to compile is skipped and the first
+// compile error is returned together with the result.
+// Example: jade.Dependents("_head.jade") returns the pages including _head.jade.
+func (this *Engine) Dependents(name string) ([]string, error) {
+	names, err := this.List()
+	if err != nil {
+		return nil, err
+	}
+	name = templateName(name)
+	result := make([]string, 0)
+	var firsterr error
+	for _, page := range names {
+		deps, err := this.Dependencies(page)
+		if err != nil {
+			if firsterr == nil {
+				firsterr = err
+			}
+			continue
+		}
+		for _, file := range deps.Files() {
+			if templateName(file) == name {
+				result = append(result, page)
+				break
+			}
+		}
+	}
+	return result, firsterr
+}
+
+// CheckError is returned by CheckAll, and contains every problem found in the templates.
+type CheckError struct {
+	Errors []error
+}
+
+func (this *CheckError) Error() string {
 	buf := new(bytes.Buffer)
-	eval := this.init(buf)
+	fmt.Fprintf(buf, "%v problems found in the templates.", len(this.Errors))
+	for _, err := range this.Errors {
+		buf.WriteString("\n" + err.Error())
+	}
+	return buf.String()
+}
+
+func (this *CheckError) Unwrap() []error {
+	return this.Errors
+}
+
+// CheckAll compiles every template in the view paths, to find broken templates at startup
+// instead of when the page is rendered. Parse errors, missing extends and include files,
+// calls to mixins not defined and blocks overriding a block not defined in the layout is
+// reported. Templates included by other templates is checked with the templates including
+// them, the mixins of the including template is visible. A *CheckError with every problem
+// found is returned, the compiled templates is cached.
+func (this *Engine) CheckAll() error {
+	names, err := this.List()
+	if err != nil {
+		return err
+	}
+	problems := make([]error, 0)
+	found := make(map[string]bool)
+	add := func(err error) {
+		if !found[err.Error()] {
+			found[err.Error()] = true
+			problems = append(problems, err)
+		}
+	}
+	compiled := make(map[string]*Template)
+	partials := make(map[string]bool)
+	for _, name := range names {
+		template, err := this.Compile(name)
+		if err != nil {
+			add(err)
+			continue
+		}
+		compiled[name] = template
+		for _, include := range template.compiled.Dependencies().Includes {
+			partials[templateName(include)] = true
+		}
+	}
+	for _, name := range names {
+		if template, ok := compiled[name]; ok && !partials[templateName(name)] {
+			for _, err := range template.compiled.Check() {
+				add(err)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &CheckError{problems}
+	}
+	return nil
+}
+
+// SaveBundle compiles every template in the view paths and writes the compiled templates
+// to w, to be loaded with LoadBundle at startup without parsing the templates again.
+// Example: jade.SaveBundle(file) in a build step, and jade.LoadBundle(file) in the application.
+func (this *Engine) SaveBundle(wr io.Writer) error {
+	names, err := this.List()
+	if err != nil {
+		return err
+	}
+	compiled := make([]*jadeparser.CompiledTemplate, 0, len(names))
+	for _, name := range names {
+		template, err := this.Compile(name)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, template.compiled)
+	}
+	return jadeparser.WriteBundle(wr, compiled)
+}
+
+// LoadBundle reads the templates written by SaveBundle and adds them to the cache, the
+// templates is rendered by the names returned by List without loading the files. Load the
+// bundle after setting the view paths and adding templates, changing them clears the cache.
+// When Reload is true the files is still checked for changes.
+func (this *Engine) LoadBundle(rd io.Reader) error {
+	compiled, err := jadeparser.ReadBundle(rd)
+	if err != nil {
+		return err
+	}
+	key := this.loaderKey()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.cacheKey != key {
+		this.templates = make(map[string]*Template)
+		this.cacheKey = key
+	}
+	for _, c := range compiled {
+		this.templates[templateName(c.Name)] = &Template{c.Name, this, c}
+	}
+	return nil
+}
+
+// List returns the names of all jade templates in the view paths and the templates
+// added with AddTemplate.
+func (this *Engine) List() ([]string, error) {
+	list, ok := this.loader().(jadeparser.ListLoader)
+	if !ok {
+		return nil, errors.New("Template loader cannot list templates.")
+	}
+	return list.List()
+}
+
+// ClearCache removes all compiled templates from the cache. Setting a different FS
+// clears the cache, call ClearCache after changing the files inside the same FS.
+func (this *Engine) ClearCache() {
+	this.mu.Lock()
+	this.templates = make(map[string]*Template)
+	this.mu.Unlock()
+}
+
+// Execute renders the template to wr.
+func (this *Template) Execute(wr io.Writer, data interface{}) error {
+	return this.ExecuteOptions(wr, data, nil)
+}
+
+// ExecuteOptions renders the template to wr, with the options overriding the engine settings.
+func (this *Template) ExecuteOptions(wr io.Writer, data interface{}, opts *RenderOptions) error {
+	return this.execute(nil, wr, data, opts)
+}
+
+// ExecuteContext renders the template to wr, and stops with ctx.Err() when ctx is canceled.
+func (this *Template) ExecuteContext(ctx context.Context, wr io.Writer, data interface{}) error {
+	return this.execute(ctx, wr, data, nil)
+}
+
+func (this *Template) execute(ctx context.Context, wr io.Writer, data interface{}, opts *RenderOptions) error {
+	eval := this.engine.init(wr)
+	if err := opts.apply(eval); err != nil {
+		return err
+	}
+	if ctx != nil {
+		eval.SetContext(ctx)
+	}
+	eval.SetData(data)
+	return eval.Execute(this.compiled)
+}
+
+// ExecuteBlock renders a single block of the template to wr.
+func (this *Template) ExecuteBlock(wr io.Writer, block string, data interface{}) error {
+	eval := this.engine.init(wr)
 	eval.SetData(data)
-	eval.RenderFile(filename)
-	return buf
+	return eval.ExecuteBlock(this.compiled, block)
+}
+
+// RenderBlock renders a single block of a jade file to wr, for updating part of a page.
+// The extends chain of the file is resolved, and the block is found the same way as
+// when the file is rendered.
+// Example: jade.RenderBlock(w, "index.jade", "content", data)
+func (this *Engine) RenderBlock(wr io.Writer, file string, block string, data interface{}) error {
+	tmpl, err := this.Compile(file)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteBlock(wr, block, data)
+}
+
+// ExecuteMixin renders a mixin of the template to wr.
+func (this *Template) ExecuteMixin(wr io.Writer, mixin string, args []interface{}, attributes map[string]interface{}) error {
+	eval := this.engine.init(wr)
+	return eval.ExecuteMixin(this.compiled, mixin, args, attributes)
+}
+
+// Generate returns Go source rendering the template without the engine, with a
+// function writing the html directly. See jadeparser.Generate.
+func (this *Template) Generate(opts jadeparser.GenerateOptions) ([]byte, error) {
+	return jadeparser.Generate(this.compiled, opts)
+}
+
+// RenderMixin renders a mixin defined in a jade file, or in the files it extends or includes, to wr.
+// The arguments and attributes is bound the same as +mixinName(args...)(attributes) from a template.
+// Example: jade.RenderMixin(w, "_mixins.jade", "card", []interface{}{"Title"}, map[string]interface{}{"class": "wide"})
+func (this *Engine) RenderMixin(wr io.Writer, file string, mixin string, args []interface{}, attributes map[string]interface{}) error {
+	tmpl, err := this.Compile(file)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteMixin(wr, mixin, args, attributes)
 }
 
-// REnderString Renders a jade string to html.
-func (this *Engine) RenderString(template string, data interface{}) *bytes.Buffer {
+// RenderContext renders a jade file to wr. Rendering stops with ctx.Err() when ctx is
+// canceled or past its deadline, and registered functions with a context.Context first
+// parameter receives ctx.
+// Example: err := jade.RenderContext(r.Context(), w, "index.jade", data)
+func (this *Engine) RenderContext(ctx context.Context, wr io.Writer, name string, data interface{}) error {
+	tmpl, err := this.Compile(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteContext(ctx, wr, data)
+}
+
+// Render renders a jade file to wr, with the options overriding the engine settings.
+// Example: jade.Render(w, "admin.jade", data, &gojade.RenderOptions{Funcs: map[string]interface{}{"user": user}})
+func (this *Engine) Render(wr io.Writer, name string, data interface{}, opts *RenderOptions) error {
+	tmpl, err := this.Compile(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteOptions(wr, data, opts)
+}
+
+// apply sets the options on the evaluator, opts can be nil.
+func (this *RenderOptions) apply(eval *jadeparser.EvalJade) error {
+	if this == nil {
+		return nil
+	}
+	if this.Beautify != nil {
+		eval.Beautify = *this.Beautify
+	}
+	if len(this.Doctype) > 0 {
+		eval.SetDoctype(this.Doctype)
+	}
+	for name, value := range this.Locals {
+		eval.SetLocal(name, value)
+	}
+	if len(this.Funcs) > 0 {
+		eval.Localfunc = make(map[string]reflect.Value)
+		for name, fn := range this.Funcs {
+			fnvalue := reflect.ValueOf(fn)
+			if fnvalue.Kind() != reflect.Func {
+				return fmt.Errorf("RenderOptions function %q is not a function.", name)
+			}
+			eval.Localfunc[name] = fnvalue
+		}
+	}
+	return nil
+}
+
+// RenderFile Renders a jade file to a bytes.Buffer.
+// Example: buf, err := jade.RenderFile("index.jade",nil)
+func (this *Engine) RenderFile(filename string, data interface{}) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	err := this.RenderFileW(buf, filename, data)
+	return buf, err
+}
+
+// RenderString Renders a jade string to html.
+func (this *Engine) RenderString(template string, data interface{}) (*bytes.Buffer, error) {
 	buf := new(bytes.Buffer)
 	eval := this.init(buf)
 	eval.SetData(data)
-	eval.RenderString(template)
-	return buf
+	err := eval.RenderString(template)
+	return buf, err
 }
 
 // RenderFileW Render a jade file to a io.writer stream.
 func (this *Engine) RenderFileW(wr io.Writer, template string, data interface{}) error {
-	eval := this.init(wr)
-	eval.SetData(data)
-	eval.RenderFile(template)
-	return nil
+	tmpl, err := this.Compile(template)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(wr, data)
+}
+
+// SetGlobal sets a variable available to every template.
+// Example: jade.SetGlobal("SiteName", "My Site")
+// SetGlobal can be called while templates is rendered, renders already started keep the
+// globals they started with.
+func (this *Engine) SetGlobal(name string, value interface{}) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	//the map is replaced with a copy, the map used by renders is never modified.
+	globals := make(map[string]interface{}, len(this.Globals)+1)
+	for k, v := range this.Globals {
+		globals[k] = v
+	}
+	globals[name] = value
+	this.Globals = globals
 }
 
 // RegisterFunction registers a function tobe called from your jade template.
@@ -61,8 +470,17 @@ func (this *Engine) RegisterFunction(name string, fn interface{}) {
 
 func (this *Engine) init(writer io.Writer) *jadeparser.EvalJade {
 	eval := jadeparser.NewEvalJade(writer)
-	eval.SetViewPath(this.ViewPath)
+	eval.Loader = this.loader()
 	eval.Beautify = this.Beautify
+	eval.Strict = this.Strict
 	eval.Extfunc = this.extfunc
+	this.mu.RLock()
+	eval.Globals = this.Globals
+	this.mu.RUnlock()
+	eval.Limits = this.Limits
+	eval.Sandbox = this.Sandbox
+	eval.FlushTags = this.FlushTags
+	eval.Observers = this.Observers
+	eval.WarningHandler = this.WarningHandler
 	return eval
 }
@@ -1,9 +1,29 @@
 package gojade
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/zdebeer99/gojade/jadeparser"
+	"github.com/zdebeer99/gojade/res/generate/views"
 )
 
 func TestRenderFiles(t *testing.T) {
@@ -12,26 +32,1073 @@ func TestRenderFiles(t *testing.T) {
 	jade.ViewPath = "res"
 	data := map[string]interface{}{"PageTitle": "Test Jade", "YouAreUsingJade": true, "Children": []string{"Mike", "Sue", "Helen"},
 		"Person": map[string]interface{}{"Name": "Ben"}}
-	save("res/html/test.html", jade.RenderFile("test", data).Bytes())
+	render(t, jade, "res/html/test.html", "test", data)
 
 	//extends
 	t.Log("Render extends.html")
 	jade.ViewPath = "res/extends"
-	save("res/html/extends.html", jade.RenderFile("index", nil).Bytes())
+	render(t, jade, "res/html/extends.html", "index", nil)
 
 	//inheritance
 	t.Log("Render inheritance")
 	jade.ViewPath = "res/inheritance"
 	data = map[string]interface{}{"title": "List of Pets", "pets": []string{"Dog", "Cat", "Bird"}}
-	save("res/html/inheritance_a.html", jade.RenderFile("page-a", data).Bytes())
-	save("res/html/inheritance_b.html", jade.RenderFile("page-b", data).Bytes())
+	render(t, jade, "res/html/inheritance_a.html", "page-a", data)
+	render(t, jade, "res/html/inheritance_b.html", "page-b", data)
 
 	//includes
 	t.Log("Render includes")
 	jade.ViewPath = "res/includes"
-	save("res/html/include.html", jade.RenderFile("index", data).Bytes())
-	save("res/html/include_text.html", jade.RenderFile("index_text", data).Bytes())
+	render(t, jade, "res/html/include.html", "index", data)
+	render(t, jade, "res/html/include_text.html", "index_text", data)
+
+}
+
+// Test errors are returned instead of panics.
+func TestRenderErrors(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res"
+	if _, err := jade.RenderFile("missing", nil); err == nil {
+		t.Error("Expecting an error for a missing file.")
+	}
+	if _, err := jade.RenderString("p= unknownfn(1)", nil); err == nil {
+		t.Error("Expecting an error for an unknown function.")
+	}
+	if _, err := jade.RenderString("p(class=", nil); err == nil {
+		t.Error("Expecting a parse error.")
+	}
+	jade.RegisterFunction("fail", func() (string, error) { return "", errors.New("failed") })
+	if _, err := jade.RenderString("p= fail()", nil); err == nil {
+		t.Error("Expecting an error from a failed function call.")
+	}
+}
+
+// Test errors are located in nested layouts and includes.
+func TestTemplateError(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/errors"
+	_, err := jade.RenderFile("index", nil)
+	var terr *jadeparser.TemplateError
+	if !errors.As(err, &terr) {
+		t.Fatalf("Expecting a TemplateError found %v", err)
+	}
+	if terr.Name != "_head.jade" || terr.Line != 2 || terr.Column != 10 {
+		t.Errorf("Invalid error location %q Line %v Column %v", terr.Name, terr.Line, terr.Column)
+	}
+	if terr.Snippet() != "  title= missingFunction()\n         ^" {
+		t.Errorf("Invalid snippet:\n%s", terr.Snippet())
+	}
+	stack := []jadeparser.StackFrame{
+		{Kind: "extends", Name: "layout.jade", Template: "index", Line: 1},
+		{Kind: "include", Name: "_head.jade", Template: "layout.jade", Line: 2},
+	}
+	if !reflect.DeepEqual(terr.Stack, stack) {
+		t.Errorf("Invalid stack %v", terr.Stack)
+	}
+
+	_, err = jade.RenderFile("parse", nil)
+	if !errors.As(err, &terr) || terr.Name != "parse" {
+		t.Errorf("Expecting a parse error on template 'parse' found %v", err)
+	}
+}
+
+// Test loading templates from a fs.FS.
+func TestRenderFS(t *testing.T) {
+	jade := New()
+	jade.FS = fstest.MapFS{
+		"views/layout.jade":  {Data: []byte("html\n  head\n    style\n      include style.css\n  body\n    include _head.jade\n    block content\n")},
+		"views/_head.jade":   {Data: []byte("h1 Header\n")},
+		"views/style.css":    {Data: []byte("h1 { color: red; }")},
+		"views/index.jade":   {Data: []byte("extends ./layout.jade\n\nblock content\n  p= title\n")},
+		"views/invalid.jade": {Data: []byte("include ../../outside.jade\n")},
+	}
+	jade.ViewPath = "views"
+	buf, err := jade.RenderFile("index", map[string]string{"title": "Hello FS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := "<html><head><style>h1 { color: red; }</style></head><body><h1>Header</h1><p>Hello FS</p></body></html>"
+	if buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+	if _, err := jade.RenderFile("invalid", nil); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expecting a invalid path error found %v", err)
+	}
+	//Templates compiled from the previous FS is not served after changing FS.
+	jade.FS = fstest.MapFS{"views/index.jade": {Data: []byte("p= title\n")}}
+	buf, err = jade.RenderFile("index", map[string]string{"title": "Other FS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := "<p>Other FS</p>"; buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+}
+
+// Test a tenant view path overriding and extending the base view path.
+func TestViewPaths(t *testing.T) {
+	jade := New()
+	jade.FS = fstest.MapFS{
+		"base/layout.jade":   {Data: []byte("html\n  body\n    block header\n      h1 Base\n    block content\n    include _foot.jade\n")},
+		"base/_foot.jade":    {Data: []byte("p Base Footer\n")},
+		"base/index.jade":    {Data: []byte("extends layout.jade\n\nblock content\n  p Content\n")},
+		"tenant/layout.jade": {Data: []byte("extends ^layout.jade\n\nblock header\n  h1 Tenant\n")},
+		"tenant/_foot.jade":  {Data: []byte("p Tenant Footer\n")},
+	}
+	jade.ViewPaths = []string{"tenant", "base"}
+	buf, err := jade.RenderFile("index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := "<html><body><h1>Tenant</h1><p>Content</p><p>Tenant Footer</p></body></html>"
+	if buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+	jade.ViewPaths = []string{"base"}
+	buf, err = jade.RenderFile("index", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html = "<html><body><h1>Base</h1><p>Content</p><p>Base Footer</p></body></html>"
+	if buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+}
 
+// Test in memory templates extending and including each other.
+func TestAddTemplate(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/extends"
+	jade.AddTemplate("base", "html\n  body\n    include _nav\n    block content\n")
+	jade.AddTemplate("_nav", "nav Menu\n")
+	jade.AddTemplate("page", "extends base\n\nblock content\n  p= title\n")
+	data := map[string]string{"title": "In Memory"}
+	html := "<html><body><nav>Menu</nav><p>In Memory</p></body></html>"
+	buf, err := jade.RenderFile("page", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+	buf, err = jade.RenderString("extends base\n\nblock content\n  p= title\n", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != html {
+		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+	//files in the view path is still found.
+	if _, err := jade.RenderFile("index", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Test templates is recompiled when a file it depends on changed.
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, modtime time.Time) {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(filename, modtime, modtime)
+	}
+	modtime := time.Now().Add(-time.Hour)
+	write("layout.jade", "html\n  block content\n", modtime)
+	write("index.jade", "extends layout.jade\n\nblock content\n  p Index\n", modtime)
+	jade := New()
+	jade.ViewPath = dir
+	expect := func(html string) {
+		buf, err := jade.RenderFile("index", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != html {
+			t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+		}
+	}
+	expect("<html><p>Index</p></html>")
+
+	write("layout.jade", "body\n  block content\n", modtime.Add(time.Minute))
+	expect("<html><p>Index</p></html>")
+	jade.Reload = true
+	expect("<body><p>Index</p></body>")
+}
+
+// Test the files a template depends on and the templates depending on a file.
+func TestDependencies(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/inheritance"
+	deps, err := jade.Dependencies("page-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deps.Extends, []string{"./sub-layout.jade", "./layout.jade"}) {
+		t.Errorf("Invalid extends chain %v", deps.Extends)
+	}
+	if !reflect.DeepEqual(deps.Blocks["content"], []string{"./sub-layout.jade", "./layout.jade"}) {
+		t.Errorf("Invalid block definitions %v", deps.Blocks["content"])
+	}
+
+	jade.ViewPath = "res/includes"
+	deps, err = jade.Dependencies("index_text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deps.Includes, []string{"_style.css", "_script.js"}) {
+		t.Errorf("Invalid includes %v", deps.Includes)
+	}
+	pages, err := jade.Dependents("_head.jade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pages, []string{"index.jade"}) {
+		t.Errorf("Invalid dependents %v", pages)
+	}
+}
+
+// Test global variables, shadowed by the data and by variables declared in the template.
+func TestGlobals(t *testing.T) {
+	jade := New()
+	jade.SetGlobal("site", "My Site")
+	jade.SetGlobal("year", 2026)
+	tmpl := "p #{site} #{year}"
+	buf, err := jade.RenderString(tmpl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>My Site 2026</p>" {
+		t.Errorf("Invalid output %q", buf.String())
+	}
+	buf, err = jade.RenderString(tmpl, map[string]interface{}{"site": "Data Site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>Data Site 2026</p>" {
+		t.Errorf("Data does not shadow global %q", buf.String())
+	}
+	buf, err = jade.RenderString("- var year = 2000\np #{site} #{year}", struct{ Title string }{"x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>My Site 2000</p>" {
+		t.Errorf("Variable does not shadow global %q", buf.String())
+	}
+	//globals set while rendering.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				jade.SetGlobal(fmt.Sprintf("global%v", i), j)
+				if _, err := jade.RenderString(tmpl, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Test options overriding the engine settings for a single render.
+func TestRenderOptions(t *testing.T) {
+	jade := New()
+	jade.AddTemplate("page", "div\n  br\n  p= greet(name)\n  p= Title")
+	beautify := false
+	opts := &RenderOptions{
+		Beautify: &beautify,
+		Doctype:  "html",
+		Locals:   map[string]interface{}{"name": "Bob"},
+		Funcs:    map[string]interface{}{"greet": func(name string) string { return "Hello " + name }},
+	}
+	buf := new(bytes.Buffer)
+	err := jade.Render(buf, "page", struct{ Title string }{"Title"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<div><br><p>Hello Bob</p><p>Title</p></div>" {
+		t.Errorf("Invalid output %q", buf.String())
+	}
+	//the engine is not changed by the options.
+	err = jade.Render(new(bytes.Buffer), "page", struct{ Title string }{"Title"}, nil)
+	if err == nil {
+		t.Errorf("Expecting function greet not found.")
+	}
+	opts.Funcs["greet"] = "not a function"
+	if err = jade.Render(new(bytes.Buffer), "page", nil, opts); err == nil {
+		t.Errorf("Expecting invalid function error.")
+	}
+}
+
+// Test rendering stops when the context is canceled, and functions receive the context.
+func TestRenderContext(t *testing.T) {
+	jade := New()
+	type key struct{}
+	jade.RegisterFunction("user", func(ctx context.Context, prefix string) string {
+		return prefix + ctx.Value(key{}).(string)
+	})
+	jade.AddTemplate("user", "p= user('Hello ')")
+	ctx := context.WithValue(context.Background(), key{}, "Bob")
+	buf := new(bytes.Buffer)
+	if err := jade.RenderContext(ctx, buf, "user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>Hello Bob</p>" {
+		t.Errorf("Invalid output %q", buf.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	jade.RegisterFunction("count", func() int {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return count
+	})
+	jade.AddTemplate("loop", "each i in 1000\n  p= count()")
+	err := jade.RenderContext(ctx, new(bytes.Buffer), "loop", nil)
+	if err != context.Canceled {
+		t.Errorf("Expecting context.Canceled found %v", err)
+	}
+	if count != 10 {
+		t.Errorf("Expecting rendering to stop after 10 iterations, found %v", count)
+	}
+
+	//the context error returned by a function canceling the render is kept in the error chain.
+	ctx, cancel = context.WithCancel(context.Background())
+	jade.RegisterFunction("slow", func(ctx context.Context) (string, error) {
+		cancel()
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	jade.AddTemplate("slow", "p= slow()")
+	err = jade.RenderContext(ctx, new(bytes.Buffer), "slow", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.Canceled found %v", err)
+	}
+}
+
+// Test templates exceeding the limits fail with ErrLimitExceeded, and include cycles are detected.
+func TestLimits(t *testing.T) {
+	jade := New()
+	jade.AddTemplate("recurse", "mixin loop\n  p\n  +loop\n+loop")
+	jade.AddTemplate("recurseargs", "mixin r(n)\n  +r(n)\n+r(1)")
+	jade.AddTemplate("count", "each i in m\n  p\neach i in 14\n  p= i")
+	jade.AddTemplate("loop", "each i in 1000\n  p= i")
+	jade.AddTemplate("nested", "each i in 20\n  each j in 20\n    p= j")
+	jade.AddTemplate("cycle", "p\ninclude _cycle")
+	jade.AddTemplate("_cycle", "p\ninclude cycle")
+	tests := []struct {
+		name   string
+		limits jadeparser.Limits
+	}{
+		{"recurse", jadeparser.Limits{MaxDepth: 20}},
+		{"recurse", jadeparser.Limits{MaxSteps: 100}},
+		{"loop", jadeparser.Limits{MaxIterations: 100}},
+		{"nested", jadeparser.Limits{MaxIterations: 100}},
+		{"loop", jadeparser.Limits{MaxOutput: 100}},
+		{"recurseargs", jadeparser.Limits{}},
+	}
+	for _, test := range tests {
+		jade.Limits = test.limits
+		err := jade.RenderFileW(new(bytes.Buffer), test.name, nil)
+		if !errors.Is(err, jadeparser.ErrLimitExceeded) {
+			t.Errorf("%s %+v: Expecting ErrLimitExceeded found %v", test.name, test.limits, err)
+		}
+	}
+	jade.Limits = jadeparser.Limits{MaxOutput: 100}
+	buf, err := jade.RenderFile("loop", nil)
+	if err == nil || buf.Len() > 100 {
+		t.Errorf("Expecting output of at most 100 bytes, found %v %v", buf.Len(), err)
+	}
+	jade.Limits = jadeparser.Limits{}
+	if err := jade.RenderFileW(new(bytes.Buffer), "loop", nil); err != nil {
+		t.Error(err)
+	}
+	//negative counts is 0 iterations, and does not lower the iterations of the render.
+	jade.Limits = jadeparser.Limits{MaxIterations: 10}
+	err = jade.RenderFileW(new(bytes.Buffer), "count", map[string]interface{}{"m": -1e6})
+	if !errors.Is(err, jadeparser.ErrLimitExceeded) {
+		t.Errorf("Negative count: Expecting ErrLimitExceeded found %v", err)
+	}
+	jade.Limits = jadeparser.Limits{}
+	err = jade.RenderFileW(new(bytes.Buffer), "count", map[string]interface{}{"m": 1e20})
+	if err == nil || !strings.Contains(err.Error(), "not a valid number of iterations") {
+		t.Errorf("Count 1e20: Expecting invalid number of iterations found %v", err)
+	}
+	err = jade.RenderFileW(new(bytes.Buffer), "cycle", nil)
+	var terr *jadeparser.TemplateError
+	if !errors.As(err, &terr) || !strings.Contains(terr.Message, "cyclic include") {
+		t.Errorf("Expecting cyclic include error found %v", err)
+	}
+}
+
+type sandboxUser struct {
+	Name    string `jade:"name"`
+	Email   string
+	deleted bool
+}
+
+func (this *sandboxUser) Delete() string {
+	this.deleted = true
+	return "deleted"
+}
+
+func (this *sandboxUser) Greeting() string {
+	return "Hello " + this.Name
+}
+
+// Test the sandbox restricting the functions, methods and fields templates can use.
+func TestSandbox(t *testing.T) {
+	jade := New()
+	jade.RegisterFunction("upper", strings.ToUpper)
+	jade.RegisterFunction("exec", func() string { return "exec" })
+	tests := []struct {
+		template string
+		sandbox  *jadeparser.Sandbox
+		output   string
+	}{
+		{"p= Greeting()", nil, "<p>Hello Bob</p>"},
+		{"p= Delete()", &jadeparser.Sandbox{DisableMethods: true}, ""},
+		{"p= upper(Name)", &jadeparser.Sandbox{DisableMethods: true}, "<p>BOB</p>"},
+		{"p= exec()", &jadeparser.Sandbox{Functions: []string{"upper"}}, ""},
+		{"p= upper(Name)", &jadeparser.Sandbox{Functions: []string{"upper"}}, "<p>BOB</p>"},
+		{"p= Email", &jadeparser.Sandbox{TagOptIn: true}, ""},
+		{"p= Name", &jadeparser.Sandbox{TagOptIn: true}, "<p>Bob</p>"},
+		{"p= Greeting()", &jadeparser.Sandbox{TagOptIn: true}, ""},
+		{"p= Greeting()", &jadeparser.Sandbox{Members: map[reflect.Type][]string{reflect.TypeOf(sandboxUser{}): {"Name", "Greeting"}}}, "<p>Hello Bob</p>"},
+		{"p= Delete()", &jadeparser.Sandbox{Members: map[reflect.Type][]string{reflect.TypeOf(sandboxUser{}): {"Name", "Greeting"}}}, ""},
+		{"p= Email", &jadeparser.Sandbox{Members: map[reflect.Type][]string{reflect.TypeOf(sandboxUser{}): {"Name", "Greeting"}}}, ""},
+	}
+	for i, test := range tests {
+		jade.Sandbox = test.sandbox
+		user := &sandboxUser{Name: "Bob", Email: "bob@example.com"}
+		buf, err := jade.RenderString(test.template, user)
+		if len(test.output) == 0 {
+			if !errors.Is(err, jadeparser.ErrNotAllowed) {
+				t.Errorf("%v. %q: Expecting ErrNotAllowed found %v", i, test.template, err)
+			}
+			if user.deleted {
+				t.Errorf("%v. %q: Delete called", i, test.template)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v. %q: %v", i, test.template, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%v. %q: Expecting %q found %q", i, test.template, test.output, buf.String())
+		}
+	}
+}
+
+type secretRole string
+
+func (this secretRole) String() string {
+	return "role called"
+}
+
+type secretUser struct {
+	Name     string `jade:"name"`
+	Password string
+	Role     secretRole `jade:"role"`
+}
+
+func (this *secretUser) String() string {
+	return "String called " + this.Password
+}
+
+func (this *secretUser) MarshalJSON() ([]byte, error) {
+	return []byte(`"MarshalJSON called"`), nil
+}
+
+// Test the sandbox writing only the allowed fields of values written to the output and passed to json.
+func TestSandboxOutput(t *testing.T) {
+	jade := New()
+	members := &jadeparser.Sandbox{Members: map[reflect.Type][]string{reflect.TypeOf(secretUser{}): {"Name", "Role"}}}
+	tests := []struct {
+		template string
+		sandbox  *jadeparser.Sandbox
+		output   string
+	}{
+		{"p!= json(u)", members, `<p>{"Name":"bob","Role":"admin"}</p>`},
+		{"p!= u", members, "<p>Name:bob,Role:admin</p>"},
+		{"p(title=u)", members, `<p title="Name:bob,Role:admin"></p>`},
+		{"p!= json(users)", members, `<p>[{"Name":"bob","Role":"admin"}]</p>`},
+		{"p!= json({user: u})", members, `<p>{"user":{"Name":"bob","Role":"admin"}}</p>`},
+		{"p!= json(byname)", members, `<p>{"bob":{"Name":"bob","Role":"admin"}}</p>`},
+		{"p!= u.Role", members, "<p>admin</p>"},
+		{"p!= json(u)", &jadeparser.Sandbox{TagOptIn: true}, `<p>{"Name":"bob","Role":"admin"}</p>`},
+		{"p!= u", &jadeparser.Sandbox{TagOptIn: true}, "<p>Name:bob,Role:admin</p>"},
+		{"p!= json(u)", nil, `<p>"MarshalJSON called"</p>`},
+	}
+	for i, test := range tests {
+		jade.Sandbox = test.sandbox
+		user := &secretUser{Name: "bob", Password: "secret", Role: "admin"}
+		data := map[string]interface{}{"u": user, "users": []secretUser{*user}, "byname": map[string]*secretUser{"bob": user}}
+		buf, err := jade.RenderString(test.template, data)
+		if err != nil {
+			t.Errorf("%v. %q: %v", i, test.template, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%v. %q: Expecting %q found %q", i, test.template, test.output, buf.String())
+		}
+	}
+}
+
+// Test rendering a single block of a page.
+func TestRenderBlock(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/inheritance"
+	tests := []struct {
+		block  string
+		output string
+	}{
+		{"primary", "<p>from page b</p>"},
+		{"content", `<div class="sidebar"><p>from page b</p></div><div class="primary"><p>from page b</p></div>`},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := jade.RenderBlock(buf, "page-b", test.block, nil); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.output {
+			t.Errorf("Block %q: Expecting %q found %q", test.block, test.output, buf.String())
+		}
+	}
+	err := jade.RenderBlock(new(bytes.Buffer), "page-b", "missing", nil)
+	var terr *jadeparser.TemplateError
+	if !errors.As(err, &terr) || terr.Message != `Block "missing" not found.` {
+		t.Errorf("Expecting block not found error, found %v", err)
+	}
+}
+
+// Test rendering a mixin from Go, with arguments and attributes.
+func TestRenderMixin(t *testing.T) {
+	jade := New()
+	jade.AddTemplate("_mixins", "mixin link(href, name)\n  a(href=href)&attributes(attributes)= name\nmixin count(n)\n  span= n + 1")
+	jade.AddTemplate("page", "include _mixins\np\n  +link('/', 'home')")
+	tests := []struct {
+		file       string
+		mixin      string
+		args       []interface{}
+		attributes map[string]interface{}
+		output     string
+	}{
+		{"_mixins", "link", []interface{}{"/foo", "foo"}, map[string]interface{}{"id": "x", "class": "btn"}, `<a href="/foo" class="btn" id="x">foo</a>`},
+		{"page", "link", []interface{}{"/foo", "foo"}, nil, `<a href="/foo">foo</a>`},
+		{"_mixins", "count", []interface{}{1}, nil, `<span>2</span>`},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := jade.RenderMixin(buf, test.file, test.mixin, test.args, test.attributes); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.output {
+			t.Errorf("Mixin %q: Expecting %q found %q", test.mixin, test.output, buf.String())
+		}
+	}
+	if err := jade.RenderMixin(new(bytes.Buffer), "_mixins", "link", []interface{}{"/foo"}, nil); err == nil {
+		t.Errorf("Expecting missing argument error.")
+	}
+	if err := jade.RenderMixin(new(bytes.Buffer), "_mixins", "missing", nil, nil); err == nil {
+		t.Errorf("Expecting mixin not found error.")
+	}
+}
+
+// flushRecorder records the output written before each flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (this *flushRecorder) Flush() {
+	this.flushed = append(this.flushed, this.String())
+}
+
+// Test output is flushed after the flush tags and at the flush keyword.
+func TestFlush(t *testing.T) {
+	jade := New()
+	jade.FlushTags = []string{"head"}
+	jade.AddTemplate("page", "html\n  head\n    title Page\n  body\n    p first\n    flush\n    p second")
+	wr := new(flushRecorder)
+	if err := jade.RenderFileW(wr, "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"<html><head><title>Page</title></head>",
+		"<html><head><title>Page</title></head><body><p>first</p>",
+	}
+	if !reflect.DeepEqual(wr.flushed, expected) {
+		t.Errorf("Expecting flushes %q found %q", expected, wr.flushed)
+	}
+	if wr.String() != "<html><head><title>Page</title></head><body><p>first</p><p>second</p></body></html>" {
+		t.Errorf("Invalid output %q", wr.String())
+	}
+}
+
+// Test a function named flush is called instead of flushing the output.
+func TestFlushFunction(t *testing.T) {
+	jade := New()
+	jade.RegisterFunction("flush", func() string { return "called" })
+	jade.AddTemplate("page", "p first\nflush\np= flush()")
+	wr := new(flushRecorder)
+	if err := jade.RenderFileW(wr, "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(wr.flushed) != 0 {
+		t.Errorf("Expecting no flushes found %q", wr.flushed)
+	}
+	if wr.String() != "<p>first</p>called<p>called</p>" {
+		t.Errorf("Invalid output %q", wr.String())
+	}
+	//data fields named flush is not affected by the keyword.
+	jade.AddTemplate("field", "p= flush")
+	wr = new(flushRecorder)
+	if err := jade.RenderFileW(wr, "field", map[string]interface{}{"flush": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if wr.String() != "<p>value</p>" {
+		t.Errorf("Invalid output %q", wr.String())
+	}
+}
+
+// Test flush is only the flush keyword alone on its line, followed by text or attributes it is a tag.
+func TestFlushTag(t *testing.T) {
+	jade := New()
+	tests := []struct {
+		template string
+		output   string
+	}{
+		{"flush Hello", "<flush>Hello</flush>"},
+		{"flush(a='b') x", `<flush a="b">x</flush>`},
+		{"flush.note", `<flush class="note"></flush>`},
+		{"flush: p x", "<flush><p>x</p></flush>"},
+		{"p a\nflush  \np b", "<p>a</p><p>b</p>"},
+	}
+	for _, test := range tests {
+		buf, err := jade.RenderString(test.template, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.template, err)
+		} else if buf.String() != test.output {
+			t.Errorf("%q: Expecting %q found %q", test.template, test.output, buf.String())
+		}
+	}
+	_, err := jade.RenderString("flush\n  p nested", nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot have nested content") {
+		t.Errorf("Expecting nested content error found %v", err)
+	}
+}
+
+// brokenWriter fails every write, like a connection closed by the client.
+type brokenWriter struct{}
+
+func (this brokenWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+// Test a write error stops rendering and is returned as a WriteError.
+func TestWriteError(t *testing.T) {
+	jade := New()
+	count := 0
+	jade.RegisterFunction("count", func() int {
+		count++
+		return count
+	})
+	jade.AddTemplate("loop", "each i in 100000\n  p= count()")
+	err := jade.RenderFileW(brokenWriter{}, "loop", nil)
+	var werr *jadeparser.WriteError
+	if !errors.As(err, &werr) || werr.Err.Error() != "broken pipe" {
+		t.Fatalf("Expecting WriteError found %v", err)
+	}
+	if count == 100000 {
+		t.Errorf("Rendering did not stop at the write error.")
+	}
+	//errors from a small template is found when the output is flushed.
+	jade.AddTemplate("small", "p small")
+	err = jade.RenderFileW(brokenWriter{}, "small", nil)
+	if !errors.As(err, &werr) {
+		t.Errorf("Expecting WriteError found %v", err)
+	}
+}
+
+// eventRecorder records the events reported to an observer.
+type eventRecorder struct {
+	events []string
+}
+
+func (this *eventRecorder) Before(event *jadeparser.Event) {
+	this.events = append(this.events, "before "+event.Kind+" "+event.Name)
+}
+
+func (this *eventRecorder) After(event *jadeparser.Event) {
+	result := "after " + event.Kind + " " + event.Name + " from " + event.Template
+	if event.Err != nil {
+		result += " failed"
+	}
+	this.events = append(this.events, result)
+}
+
+// Test observers is notified of renders, includes, mixin and function calls.
+func TestObservers(t *testing.T) {
+	jade := New()
+	recorder := new(eventRecorder)
+	jade.Observers = []jadeparser.Observer{recorder}
+	jade.RegisterFunction("fail", func() (string, error) { return "", errors.New("failed") })
+	jade.RegisterFunction("shout", strings.ToUpper)
+	jade.AddTemplate("_part", "mixin item(name)\n  li= shout(name)")
+	jade.AddTemplate("page", "include _part\nul\n  +item('a')")
+	if err := jade.RenderFileW(new(bytes.Buffer), "page", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"before render page",
+		"before include _part",
+		"after include _part from page",
+		"before mixin item",
+		"before function shout",
+		"after function shout from _part",
+		"after mixin item from page",
+		"after render page from ",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("Expecting events %q found %q", expected, recorder.events)
+	}
+	recorder.events = nil
+	jade.AddTemplate("fail", "p= fail()")
+	jade.RenderFileW(new(bytes.Buffer), "fail", nil)
+	expected = []string{
+		"before render fail",
+		"before function fail",
+		"after function fail from fail failed",
+		"after render fail from  failed",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("Expecting events %q found %q", expected, recorder.events)
+	}
+	recorder.events = nil
+	jade.AddTemplate("layout", "div\n  block content")
+	jade.AddTemplate("base", "extends layout\nblock content\n  p base")
+	jade.AddTemplate("child", "extends base\nblock content\n  p= shout('a')")
+	if err := jade.RenderFileW(new(bytes.Buffer), "child", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"before render child",
+		"before extends base",
+		"before extends layout",
+		"before function shout",
+		"after function shout from child",
+		"after extends layout from base",
+		"after extends base from child",
+		"after render child from ",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("Expecting events %q found %q", expected, recorder.events)
+	}
+}
+
+// Test warnings is reported to the warning handler.
+func TestWarnings(t *testing.T) {
+	jade := New()
+	warnings := make([]string, 0)
+	jade.WarningHandler = func(warning *jadeparser.Warning) {
+		warnings = append(warnings, warning.String())
+	}
+	jade.AddTemplate("page", "p. \n  text\np= missing\np= Title")
+	if err := jade.RenderFileW(new(bytes.Buffer), "page", struct{ Title string }{"Title"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`Template "page" Line 1 Column 3 Warning: Space found after Block Content character. '.'`,
+		`Template "page" Line 3 Column 4 Warning: Variable "missing" not defined.`,
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expecting warnings %q found %q", expected, warnings)
+	}
+}
+
+// Test undefined variables is an error in strict mode.
+func TestStrict(t *testing.T) {
+	type user struct {
+		Name   string
+		Parent *user
+	}
+	jade := New()
+	data := map[string]interface{}{"user": &user{Name: "Bob"}}
+	tests := []struct {
+		template string
+		message  string
+	}{
+		{"p= user.Name", ""},
+		{"p= user.Nmae", `Variable "user.Nmae" not defined.`},
+		{"p= missing", `Variable "missing" not defined.`},
+		{"p= user.Parent.Name", `Variable "user.Parent.Name" not defined.`},
+		{"mixin m\n  if block\n    block\n+m", ""},
+	}
+	for _, test := range tests {
+		jade.Strict = false
+		if _, err := jade.RenderString(test.template, data); err != nil {
+			t.Errorf("%q: Expecting no error when not strict, found %v", test.template, err)
+		}
+		jade.Strict = true
+		_, err := jade.RenderString(test.template, data)
+		var terr *jadeparser.TemplateError
+		if len(test.message) == 0 {
+			if err != nil {
+				t.Errorf("%q: %v", test.template, err)
+			}
+		} else if !errors.As(err, &terr) || terr.Message != test.message || terr.Line != 1 {
+			t.Errorf("%q: Expecting %q found %v", test.template, test.message, err)
+		}
+	}
+}
+
+// Test checking every template in the view path.
+func TestCheckAll(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/check"
+	err := jade.CheckAll()
+	var cerr *CheckError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expecting CheckError found %v", err)
+	}
+	problems := make([]string, 0)
+	for _, err := range cerr.Errors {
+		var terr *jadeparser.TemplateError
+		if !errors.As(err, &terr) {
+			t.Fatalf("Expecting TemplateError found %v", err)
+		}
+		problems = append(problems, fmt.Sprintf("%s %v", terr.Name, terr.Line))
+	}
+	expected := []string{"include.jade 2", "orphan.jade 1", "broken.jade 4", "broken.jade 3", "nested.jade 5"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expecting problems %q found %q", expected, problems)
+	}
+	if !strings.Contains(err.Error(), `Line 4 Column 3 Mixin "buton" not found.`) ||
+		!strings.Contains(err.Error(), `Line 3 Column 1 Block "contnet" not defined in the templates extended.`) {
+		t.Errorf("Invalid problems %v", err)
+	}
+}
+
+// Test templates loaded from a bundle renders the same as templates loaded from the files,
+// without reading the files.
+func TestBundle(t *testing.T) {
+	data := map[string]interface{}{"title": "List of Pets", "pets": []string{"Dog", "Cat", "Bird"}}
+	for _, viewpath := range []string{"res/includes", "res/inheritance", "res/extends"} {
+		jade := New()
+		jade.ViewPath = viewpath
+		bundle := new(bytes.Buffer)
+		if err := jade.SaveBundle(bundle); err != nil {
+			t.Fatal(err)
+		}
+		loaded := New()
+		loaded.ViewPath = viewpath
+		loaded.FS = fstest.MapFS{}
+		if err := loaded.LoadBundle(bundle); err != nil {
+			t.Fatal(err)
+		}
+		names, err := jade.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			expected, actual := new(bytes.Buffer), new(bytes.Buffer)
+			if err := jade.Render(expected, name, data, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := loaded.Render(actual, name, data, nil); err != nil {
+				t.Fatalf("%s/%s: %v", viewpath, name, err)
+			}
+			if expected.String() != actual.String() {
+				t.Errorf("%s/%s does not match:\nExpected:\n%s\nFound:\n%s", viewpath, name, expected, actual)
+			}
+		}
+	}
+	if err := New().LoadBundle(strings.NewReader("not a bundle")); err == nil {
+		t.Error("Expecting an error loading an invalid bundle.")
+	}
+}
+
+// Test templates is rendered from a bundle by name without the extension, after the view path is removed.
+func TestBundleRemovedViewPath(t *testing.T) {
+	viewpath := filepath.Join(t.TempDir(), "views")
+	if err := os.MkdirAll(viewpath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"layout.jade", "page-a.jade"} {
+		content, err := ioutil.ReadFile(filepath.Join("res/inheritance", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(viewpath, name), content, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jade := New()
+	jade.ViewPath = viewpath
+	bundle := new(bytes.Buffer)
+	if err := jade.SaveBundle(bundle); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New()
+	loaded.ViewPath = viewpath
+	if err := loaded.LoadBundle(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(viewpath); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"title": "List of Pets", "pets": []string{"Dog", "Cat", "Bird"}}
+	buf, err := loaded.RenderFile("page-a", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<div>Cat</div>") {
+		t.Errorf("Invalid output %q", buf.String())
+	}
+}
+
+// updateGenerated writes the source generated by TestGenerate to res/generate/views.
+var updateGenerated = flag.Bool("update", false, "write the source generated by TestGenerate to res/generate/views")
+
+// Test the Go source generated for a template accesses the data without reflection, and
+// renders the same as the evaluator. The generated source is compared to the source compiled
+// with the test in res/generate/views, run go test -update to write it.
+func TestGenerate(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "res/generate/views/page.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("github.com/zdebeer99/gojade/res/generate/views", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := jadeparser.GenerateOptions{Type: types.NewPointer(pkg.Scope().Lookup("Page").Type()), Pkg: pkg}
+	jade := New()
+	jade.ViewPath = "res/generate"
+	tmpl, err := jade.Compile("index.jade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := tmpl.Generate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateGenerated {
+		if err := ioutil.WriteFile("res/generate/views/index_jade.go", source, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compiled, err := ioutil.ReadFile("res/generate/views/index_jade.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(source, compiled) {
+		t.Errorf("The generated source differs from res/generate/views/index_jade.go, run go test -update. Generated:\n%s", source)
+	}
+	for _, expected := range []string{
+		"func RenderIndex(wr io.Writer, data *Page) (err error) {",
+		"for i_, entry_ := range data.Items {",
+		"func(entry_ Item, index_ int, attributes_ *jadeparser.LinearMap, block_ func())",
+		"w.WriteString(html.EscapeString(entry_.Name))",
+		"if float64(index_) == 0 {",
+	} {
+		if !bytes.Contains(source, []byte(expected)) {
+			t.Errorf("Expecting %q in the generated source:\n%s", expected, source)
+		}
+	}
+
+	//the generated source renders the same as the evaluator, nil pointers is zero values.
+	items := []views.Item{{Name: "Dog", Link: "/dog", Price: 10}, {Name: "Cat", Link: "/cat", Price: 2.5}, {Name: "Bird", Link: "/bird", Price: 1}}
+	for i, data := range []*views.Page{
+		{Site: &views.Site{SiteName: "Pets"}, Title: "Pets", Count: 2, User: &views.User{Name: "Bob", Admin: true}, Items: items},
+		{Title: "Pets", Count: 5, User: &views.User{Name: "<Sue>"}, Items: items[:1]},
+		{Title: "Empty"},
+	} {
+		expected := new(bytes.Buffer)
+		if err := tmpl.Execute(expected, data); err != nil {
+			t.Fatal(err)
+		}
+		generated := new(bytes.Buffer)
+		if err := views.RenderIndex(generated, data); err != nil {
+			t.Fatal(err)
+		}
+		if generated.String() != expected.String() {
+			t.Errorf("%v. Generated output does not match:\nExpected:\n%s\nGenerated:\n%s", i, expected, generated)
+		}
+	}
+
+	//errors is located at the statement that failed, the same as the evaluator.
+	data := &views.Page{Title: "Pets", Count: -1}
+	experr := tmpl.Execute(new(bytes.Buffer), data)
+	generr := views.RenderIndex(new(bytes.Buffer), data)
+	var expected, generated *jadeparser.TemplateError
+	if !errors.As(experr, &expected) || !errors.As(generr, &generated) {
+		t.Fatalf("Expecting template errors found %v and %v", experr, generr)
+	}
+	if generated.Name != expected.Name || generated.Line != expected.Line || !strings.Contains(generated.Message, "negative count") {
+		t.Errorf("Expecting error %v found %v", experr, generr)
+	}
+
+	for _, test := range []struct {
+		jade    string
+		message string
+	}{
+		{"p= Title\n+button('Go')", `Mixin "button" not found.`},
+		{"p= Title\np= User.Nmae", `Variable "Nmae" not defined on *views.User.`},
+		{"p= Title\np= shout(Title)", `Function "shout" not found on *views.Page, registered functions is not supported in generated templates.`},
+		{"p= Title\np= siteName", `Variable "siteName" not defined on *views.Page, globals is not supported in generated templates.`},
+		{"- var name = Title\np= name.Length", `Variable "Length" not defined on string.`},
+	} {
+		jade.AddTemplate("invalid", test.jade)
+		tmpl, err = jade.Compile("invalid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tmpl.Generate(opts)
+		var terr *jadeparser.TemplateError
+		if !errors.As(err, &terr) || terr.Line != 2 || terr.Message != test.message {
+			t.Errorf("Expecting error %q on line 2, found %v", test.message, err)
+		}
+	}
+}
+
+// Test a compiled template rendering from multiple goroutines.
+func TestCompile(t *testing.T) {
+	jade := New()
+	jade.ViewPath = "res/inheritance"
+	tmpl, err := jade.Compile("page-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := jade.Compile("page-a"); cached != tmpl {
+		t.Error("Expecting the compiled template to be cached.")
+	}
+	html := `<html><head><title>My Site - List of Pets</title><script src="/jquery.js"></script><script src="/pets.js"></script></head><body><h1>List of Pets</h1><div>Dog</div><div>Cat</div><div>Bird</div><div id="footer"><p>some footer content</p></div></body></html>`
+	data := map[string]interface{}{"title": "List of Pets", "pets": []string{"Dog", "Cat", "Bird"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				buf := new(bytes.Buffer)
+				if err := tmpl.Execute(buf, data); err != nil {
+					t.Error(err)
+					return
+				}
+				if buf.String() != html {
+					t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 func load(filename string) (string, error) {
@@ -42,6 +1109,15 @@ func load(filename string) (string, error) {
 	return string(data), nil
 }
 
+// render renders a jade file and saves the result.
+func render(t *testing.T, jade *Engine, filename string, template string, data interface{}) {
+	buf, err := jade.RenderFile(template, data)
+	if err != nil {
+		t.Error(err)
+	}
+	save(filename, buf.Bytes())
+}
+
 func save(filename string, data []byte) {
 	ioutil.WriteFile(filename, data, os.ModePerm)
 }
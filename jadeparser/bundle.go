@@ -0,0 +1,465 @@
+package jadeparser
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// bundleVersion is increased when the bundle format changes, bundles of other versions is rejected.
+const bundleVersion = 1
+
+// Kinds of tokens in a bundleNode.
+const (
+	bundleEmpty byte = iota
+	bundleError
+	bundleNumber
+	bundleBool
+	bundleFunc
+	bundleOperator
+	bundleLRFunc
+	bundleGroup
+	bundleHtmlTag
+	bundleDocType
+	bundleText
+	bundleKeyValue
+	bundleComment
+)
+
+// bundle is the serialized form of a list of compiled templates. Templates shared
+// by the compiled templates, like a layout, is stored once.
+type bundle struct {
+	Version   int
+	Templates []*bundleTemplate
+	Compiled  []*bundleCompiled
+}
+
+type bundleTemplate struct {
+	Name     string
+	File     []byte
+	IsJade   bool
+	Path     string
+	Layer    int
+	ModTime  time.Time
+	Root     *bundleNode
+	Log      []string
+	Mixins   map[string]*bundlePart
+	Blocks   map[string]*bundlePart
+	Extends  string
+	Warnings []*Warning
+}
+
+// bundlePart is a mixin or block of a template, stored as the path to the node in
+// the template tree, or as the node when the node is not in the tree.
+type bundlePart struct {
+	Path []int
+	Node *bundleNode
+}
+
+type bundleCompiled struct {
+	Name  string
+	Chain []int //Index of the templates in bundle.Templates.
+	Loads []bundleLoad
+}
+
+type bundleLoad struct {
+	Name     string
+	Layer    int
+	Template int
+}
+
+// bundleNode is the serialized form of a TreeNode and its token.
+type bundleNode struct {
+	Kind    byte
+	Pos     int
+	Text    string  //Name, text, operator, tag name, key, group type or comment type of the token.
+	Number  float64 //Value of a number.
+	Flag    bool    //Value of a bool, IsIdentity of a function or SelfClosing of a tag.
+	Strings []string
+	Nodes   []*bundleNode //Arguments of a function or attributes of a tag.
+	Next    *bundleNode   //Next function in a chain.
+	Index   *bundleNode   //Index of a function or value of a key value.
+	Items   []*bundleNode
+	Err     string
+}
+
+// WriteBundle writes compiled templates to w in a binary format, read back with
+// ReadBundle without parsing the templates again.
+func WriteBundle(w io.Writer, compiled []*CompiledTemplate) error {
+	enc := &bundleEncoder{bundle: &bundle{Version: bundleVersion}, index: make(map[*Template]int)}
+	for _, c := range compiled {
+		if err := enc.addCompiled(c); err != nil {
+			return err
+		}
+	}
+	return gob.NewEncoder(w).Encode(enc.bundle)
+}
+
+// ReadBundle reads compiled templates written by WriteBundle.
+func ReadBundle(r io.Reader) ([]*CompiledTemplate, error) {
+	b := new(bundle)
+	if err := gob.NewDecoder(r).Decode(b); err != nil {
+		return nil, fmt.Errorf("Invalid template bundle. %v", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("Template bundle version %v not supported, expecting version %v.", b.Version, bundleVersion)
+	}
+	templates := make([]*Template, len(b.Templates))
+	for i, t := range b.Templates {
+		template, err := t.decode()
+		if err != nil {
+			return nil, err
+		}
+		templates[i] = template
+	}
+	find := func(index int) (*Template, error) {
+		if index < 0 || index >= len(templates) {
+			return nil, errors.New("Invalid template bundle. Template index out of range.")
+		}
+		return templates[index], nil
+	}
+	result := make([]*CompiledTemplate, 0, len(b.Compiled))
+	for _, c := range b.Compiled {
+		if len(c.Chain) == 0 {
+			return nil, fmt.Errorf("Invalid template bundle. Template %q has no extends chain.", c.Name)
+		}
+		compiled := &CompiledTemplate{
+			Name:      c.Name,
+			Chain:     make([]*Template, 0, len(c.Chain)),
+			Templates: make(map[string]*Template),
+			Blocks:    make(map[string]*jadePart),
+			Mixins:    make(map[string]*jadePart),
+		}
+		for _, load := range c.Loads {
+			template, err := find(load.Template)
+			if err != nil {
+				return nil, err
+			}
+			compiled.Templates[templateKey(load.Name, load.Layer)] = template
+			compiled.loads = append(compiled.loads, templateLoad{load.Name, load.Layer, template})
+		}
+		for _, index := range c.Chain {
+			template, err := find(index)
+			if err != nil {
+				return nil, err
+			}
+			compiled.Chain = append(compiled.Chain, template)
+			if template.IsJade {
+				compiled.addParts(template)
+			}
+		}
+		compiled.Root = compiled.Chain[len(compiled.Chain)-1]
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+type bundleEncoder struct {
+	bundle *bundle
+	index  map[*Template]int
+}
+
+func (this *bundleEncoder) addCompiled(compiled *CompiledTemplate) error {
+	c := &bundleCompiled{Name: compiled.Name, Chain: make([]int, 0, len(compiled.Chain)), Loads: make([]bundleLoad, 0, len(compiled.loads))}
+	for _, load := range compiled.loads {
+		index, err := this.addTemplate(load.template)
+		if err != nil {
+			return err
+		}
+		c.Loads = append(c.Loads, bundleLoad{load.name, load.layer, index})
+	}
+	for _, template := range compiled.Chain {
+		index, err := this.addTemplate(template)
+		if err != nil {
+			return err
+		}
+		c.Chain = append(c.Chain, index)
+	}
+	this.bundle.Compiled = append(this.bundle.Compiled, c)
+	return nil
+}
+
+// addTemplate adds a template to the bundle and returns its index. A template
+// loaded from the same file by another compiled template is only added once.
+func (this *bundleEncoder) addTemplate(template *Template) (int, error) {
+	if index, ok := this.index[template]; ok {
+		return index, nil
+	}
+	for i, t := range this.bundle.Templates {
+		if t.Name == template.Name && t.Path == template.Path && t.Layer == template.Layer &&
+			t.ModTime.Equal(template.ModTime) && bytes.Equal(t.File, template.File) {
+			this.index[template] = i
+			return i, nil
+		}
+	}
+	t := &bundleTemplate{
+		Name:    template.Name,
+		File:    template.File,
+		IsJade:  template.IsJade,
+		Path:    template.Path,
+		Layer:   template.Layer,
+		ModTime: template.ModTime,
+	}
+	if template.IsJade {
+		result := template.Root
+		if result.Err != nil {
+			return 0, result.Err
+		}
+		paths := make(map[*TreeNode][]int)
+		nodePaths(result.Root, []int{}, paths)
+		t.Root = encodeNode(result.Root)
+		t.Log = result.Log
+		t.Mixins = encodeParts(result.Mixins, paths)
+		t.Blocks = encodeParts(result.Blocks, paths)
+		t.Extends = result.Extends
+		t.Warnings = result.Warnings
+	}
+	this.index[template] = len(this.bundle.Templates)
+	this.bundle.Templates = append(this.bundle.Templates, t)
+	return this.index[template], nil
+}
+
+func (this *bundleTemplate) decode() (*Template, error) {
+	template := &Template{
+		Name:    this.Name,
+		File:    this.File,
+		IsJade:  this.IsJade,
+		Path:    this.Path,
+		Layer:   this.Layer,
+		ModTime: this.ModTime,
+	}
+	if !this.IsJade {
+		return template, nil
+	}
+	if this.Root == nil {
+		return nil, fmt.Errorf("Invalid template bundle. Template %q has no content.", this.Name)
+	}
+	root, err := decodeNode(this.Root)
+	if err != nil {
+		return nil, err
+	}
+	result := &ParseResult{Root: root, Log: this.Log, Extends: this.Extends, Warnings: this.Warnings}
+	if result.Log == nil {
+		result.Log = make([]string, 0)
+	}
+	if result.Mixins, err = decodeParts(this.Mixins, root); err != nil {
+		return nil, err
+	}
+	if result.Blocks, err = decodeParts(this.Blocks, root); err != nil {
+		return nil, err
+	}
+	compilePlans(result)
+	template.Root = result
+	return template, nil
+}
+
+// nodePaths records the index path of every node in the tree.
+func nodePaths(node *TreeNode, path []int, paths map[*TreeNode][]int) {
+	paths[node] = path
+	for i, item := range node.items {
+		nodePaths(item, append(append(make([]int, 0, len(path)+1), path...), i), paths)
+	}
+}
+
+func encodeParts(parts map[string]*TreeNode, paths map[*TreeNode][]int) map[string]*bundlePart {
+	result := make(map[string]*bundlePart)
+	for name, node := range parts {
+		if path, ok := paths[node]; ok {
+			result[name] = &bundlePart{Path: path}
+		} else {
+			result[name] = &bundlePart{Node: encodeNode(node)}
+		}
+	}
+	return result
+}
+
+func decodeParts(parts map[string]*bundlePart, root *TreeNode) (map[string]*TreeNode, error) {
+	result := make(map[string]*TreeNode)
+	for name, part := range parts {
+		if part.Node != nil {
+			node, err := decodeNode(part.Node)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = node
+			continue
+		}
+		node := root
+		for _, i := range part.Path {
+			if i < 0 || i >= len(node.items) {
+				return nil, fmt.Errorf("Invalid template bundle. Path of %q not found.", name)
+			}
+			node = node.items[i]
+		}
+		result[name] = node
+	}
+	return result, nil
+}
+
+func encodeNode(node *TreeNode) *bundleNode {
+	if node == nil {
+		return nil
+	}
+	result := encodeToken(node.Value)
+	result.Pos = node.Pos
+	for _, item := range node.items {
+		result.Items = append(result.Items, encodeNode(item))
+	}
+	return result
+}
+
+func encodeNodes(nodes []*TreeNode) []*bundleNode {
+	result := make([]*bundleNode, len(nodes))
+	for i, node := range nodes {
+		result[i] = encodeNode(node)
+	}
+	return result
+}
+
+func encodeToken(token Token) *bundleNode {
+	result := new(bundleNode)
+	switch t := token.(type) {
+	case *EmptyToken:
+		result.Kind = bundleEmpty
+	case *ErrorToken:
+		result.Kind = bundleError
+	case *NumberToken:
+		result.Kind, result.Number = bundleNumber, t.Value
+	case *BoolToken:
+		result.Kind, result.Flag = bundleBool, t.Value
+	case *FuncToken:
+		result.Kind, result.Text, result.Flag = bundleFunc, t.Name, t.IsIdentity
+		result.Nodes = encodeNodes(t.Arguments)
+		result.Index = encodeNode(t.Index)
+		if t.Next != nil {
+			result.Next = encodeToken(t.Next)
+		}
+	case *OperatorToken:
+		result.Kind, result.Text = bundleOperator, t.Operator
+	case *LRFuncToken:
+		result.Kind, result.Text = bundleLRFunc, t.Name
+	case *GroupToken:
+		result.Kind, result.Text = bundleGroup, t.GroupType
+	case *HtmlTagToken:
+		result.Kind, result.Text, result.Flag = bundleHtmlTag, t.TagName, t.SelfClosing
+		result.Nodes = encodeNodes(t.Attributes)
+	case *HtmlDocTypeToken:
+		result.Kind, result.Strings = bundleDocType, t.Attributes
+	case *TextToken:
+		result.Kind, result.Text = bundleText, t.Text
+	case *KeyValueToken:
+		result.Kind, result.Text = bundleKeyValue, t.Key
+		result.Index = encodeNode(t.Value)
+	case *CommentToken:
+		result.Kind, result.Text = bundleComment, t.CommentType
+	default:
+		panic(fmt.Errorf("Token %T cannot be added to a bundle.", token))
+	}
+	if err := token.Error(); err != nil {
+		result.Err = err.Error()
+	}
+	return result
+}
+
+func decodeNode(data *bundleNode) (*TreeNode, error) {
+	if data == nil {
+		return nil, nil
+	}
+	token, err := decodeToken(data)
+	if err != nil {
+		return nil, err
+	}
+	node := NewTreeNode(token)
+	node.Pos = data.Pos
+	for _, item := range data.Items {
+		child, err := decodeNode(item)
+		if err != nil {
+			return nil, err
+		}
+		node.AddElement(child)
+	}
+	return node, nil
+}
+
+func decodeNodes(data []*bundleNode) ([]*TreeNode, error) {
+	result := make([]*TreeNode, len(data))
+	for i, item := range data {
+		node, err := decodeNode(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = node
+	}
+	return result, nil
+}
+
+func decodeToken(data *bundleNode) (token Token, err error) {
+	switch data.Kind {
+	case bundleEmpty:
+		token = NewEmptyToken()
+	case bundleError:
+		return &ErrorToken{EmptyToken{CatOther, errors.New(data.Err)}}, nil
+	case bundleNumber:
+		token = &NumberToken{EmptyToken{CatValue, nil}, data.Number}
+	case bundleBool:
+		token = &BoolToken{EmptyToken{CatValue, nil}, data.Flag}
+	case bundleFunc:
+		fn := NewFuncToken(data.Text)
+		fn.IsIdentity = data.Flag
+		if fn.Arguments, err = decodeNodes(data.Nodes); err != nil {
+			return nil, err
+		}
+		if fn.Index, err = decodeNode(data.Index); err != nil {
+			return nil, err
+		}
+		if data.Next != nil {
+			next, err := decodeToken(data.Next)
+			if err != nil {
+				return nil, err
+			}
+			if fn.Next, _ = next.(*FuncToken); fn.Next == nil {
+				return nil, errors.New("Invalid template bundle. Expecting a function in a function chain.")
+			}
+		}
+		token = fn
+	case bundleOperator:
+		if operators.Level(data.Text) < 0 {
+			return nil, fmt.Errorf("Invalid template bundle. Invalid Operator %q", data.Text)
+		}
+		token = NewOperatorToken(data.Text)
+	case bundleLRFunc:
+		token = NewLRFuncToken(data.Text)
+	case bundleGroup:
+		token = NewGroupToken(data.Text)
+	case bundleHtmlTag:
+		tag := NewHtmlTagToken(data.Text)
+		tag.SelfClosing = data.Flag
+		if tag.Attributes, err = decodeNodes(data.Nodes); err != nil {
+			return nil, err
+		}
+		token = tag
+	case bundleDocType:
+		doctype := NewHtmlDocTypeToken()
+		doctype.Attributes = append(doctype.Attributes, data.Strings...)
+		token = doctype
+	case bundleText:
+		token = NewTextToken(data.Text)
+	case bundleKeyValue:
+		value, err := decodeNode(data.Index)
+		if err != nil {
+			return nil, err
+		}
+		token = NewKeyValueToken(data.Text, value)
+	case bundleComment:
+		token = NewCommentToken(data.Text)
+	default:
+		return nil, fmt.Errorf("Invalid template bundle. Unknown token kind %v.", data.Kind)
+	}
+	if data.Err != "" {
+		token.SetError(errors.New(data.Err))
+	}
+	return token, nil
+}
@@ -0,0 +1,108 @@
+package jadeparser
+
+import (
+	"fmt"
+)
+
+// Check returns the problems found in a compiled template without rendering it: mixin
+// calls naming a mixin not defined by the compiled templates, and blocks overriding
+// a block not defined by the templates extended.
+func (this *CompiledTemplate) Check() []*TemplateError {
+	result := make([]*TemplateError, 0)
+	mixins := make(map[string]bool)
+	for _, load := range this.loads {
+		if load.template.IsJade {
+			for name := range load.template.Root.Mixins {
+				mixins[name] = true
+			}
+		}
+	}
+	for _, load := range this.loads {
+		if !load.template.IsJade {
+			continue
+		}
+		for _, node := range findMixinCalls(load.template.Root.Root) {
+			name := node.Value.(*FuncToken).Arguments[0].Value.(*FuncToken).Name
+			if !mixins[name] {
+				result = append(result, checkError(load.template, node, "Mixin %q not found.", name))
+			}
+		}
+	}
+	//blocks at the root of a template extending a layout override the blocks of the layout,
+	//and so does the blocks nested in the overrides.
+	for i, template := range this.Chain[:len(this.Chain)-1] {
+		blocks := this.parentBlocks(i)
+		for _, item := range template.Root.Root.Items() {
+			if _, ok := blockName(item); !ok {
+				continue
+			}
+			for _, node := range findBlocks(item) {
+				if name, _ := blockName(node); !blocks[name] {
+					result = append(result, checkError(template, node, "Block %q not defined in the templates extended.", name))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// findBlocks returns the named block statements in the tree.
+func findBlocks(node *TreeNode) []*TreeNode {
+	result := make([]*TreeNode, 0)
+	if _, ok := blockName(node); ok {
+		result = append(result, node)
+	}
+	for _, item := range node.Items() {
+		result = append(result, findBlocks(item)...)
+	}
+	return result
+}
+
+// parentBlocks returns the blocks defined by the templates extended by Chain[index],
+// and by the files included.
+func (this *CompiledTemplate) parentBlocks(index int) map[string]bool {
+	result := make(map[string]bool)
+	for _, template := range this.Chain[index+1:] {
+		if template.IsJade {
+			for name := range template.Root.Blocks {
+				result[name] = true
+			}
+		}
+	}
+	for _, load := range this.loads {
+		if load.template.IsJade && !this.inChain(load.template) {
+			for name := range load.template.Root.Blocks {
+				result[name] = true
+			}
+		}
+	}
+	return result
+}
+
+func checkError(template *Template, node *TreeNode, format string, args ...interface{}) *TemplateError {
+	return newTemplateError(template.Name, template.File, node.Pos, fmt.Sprintf(format, args...))
+}
+
+// findMixinCalls returns the +mixin calls in the tree.
+func findMixinCalls(node *TreeNode) []*TreeNode {
+	result := make([]*TreeNode, 0)
+	if fn, ok := node.Value.(*FuncToken); ok && fn.Name == jadeMixinFunc && len(fn.Arguments) > 0 {
+		if _, ok := fn.Arguments[0].Value.(*FuncToken); ok {
+			result = append(result, node)
+		}
+	}
+	for _, item := range node.Items() {
+		result = append(result, findMixinCalls(item)...)
+	}
+	return result
+}
+
+// blockName returns the name of a block statement.
+func blockName(node *TreeNode) (string, bool) {
+	if fn, ok := node.Value.(*FuncToken); ok && fn.Name == jadeBlockFunc && len(fn.Arguments) > 0 {
+		if name, ok := fn.Arguments[0].Value.(*FuncToken); ok {
+			return name.Name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,241 @@
+package jadeparser
+
+// CompiledTemplate is a jade template with its extends chain, mixins and blocks
+// resolved and every included file loaded. A CompiledTemplate is never
+// modified after Compile returns and can be executed by many EvalJade
+// instances at the same time.
+type CompiledTemplate struct {
+	Name string
+	//Root is the outer most layout in the extends chain, the template that is executed.
+	Root *Template
+	//Chain is the compiled template followed by the templates it extends.
+	Chain []*Template
+	//Templates contains every template loaded while compiling, indexed by the name used to load it.
+	//See templateKey.
+	Templates map[string]*Template
+	Blocks    map[string]*jadePart
+	Mixins    map[string]*jadePart
+	loads     []templateLoad
+}
+
+// templateLoad records a template loaded while compiling, and the layer it was loaded from.
+type templateLoad struct {
+	name     string
+	layer    int
+	template *Template
+}
+
+// Compile loads the named template through the loader and resolves its extends
+// chain, mixins, blocks and includes.
+func Compile(loader TemplateLoader, name string) (result *CompiledTemplate, err error) {
+	defer errRecover(&err)
+	compiled := &CompiledTemplate{
+		Name:      name,
+		Chain:     make([]*Template, 0),
+		Templates: make(map[string]*Template),
+		Blocks:    make(map[string]*jadePart),
+		Mixins:    make(map[string]*jadePart),
+	}
+	stack := make([]StackFrame, 0)
+	template := compiled.load(loader, name, nil, nil, stack)
+	for {
+		for _, t := range compiled.Chain {
+			if t == template {
+				panic(&TemplateError{Name: template.Name, Message: "Template extends itself.", Stack: append([]StackFrame(nil), stack...)})
+			}
+		}
+		compiled.Chain = append(compiled.Chain, template)
+		if !template.IsJade {
+			break
+		}
+		compiled.addParts(template)
+		if len(template.Root.Extends) == 0 {
+			break
+		}
+		node := findExtends(template.Root.Root)
+		stack = append(stack, newStackFrame("extends", template.Root.Extends, template, node))
+		template = compiled.load(loader, template.Root.Extends, template, node, stack)
+	}
+	compiled.Root = template
+	return compiled, nil
+}
+
+// load loads a template and every file it includes. from and node is the template
+// and node the template is loaded from, and is used to locate load errors.
+func (this *CompiledTemplate) load(loader TemplateLoader, name string, from *Template, node *TreeNode, stack []StackFrame) *Template {
+	layer := 0
+	if from != nil {
+		layer = from.Layer
+	}
+	key := templateKey(name, layer)
+	if template, ok := this.Templates[key]; ok {
+		return template
+	}
+	template, err := loadTemplate(loader, name, layer)
+	if err != nil {
+		if terr, ok := err.(*TemplateError); ok {
+			terr.Stack = append([]StackFrame(nil), stack...)
+			panic(terr)
+		}
+		terr := &TemplateError{Message: err.Error(), Err: err, Stack: append([]StackFrame(nil), stack...)}
+		if from != nil {
+			terr.Name = from.Name
+			if node != nil {
+				terr.setPosition(from.File, node.Pos)
+			}
+			//the frame loading the template is reported as the error location.
+			terr.Stack = stack[:len(stack)-1]
+		}
+		panic(terr)
+	}
+	this.Templates[key] = template
+	this.loads = append(this.loads, templateLoad{name, layer, template})
+	if template.IsJade {
+		for _, include := range findIncludes(template.Root.Root) {
+			filename := include.Value.(*FuncToken).Arguments[0].Value.(*TextToken).Text
+			this.load(loader, filename, template, include, append(stack, newStackFrame("include", filename, template, include)))
+		}
+	}
+	return template
+}
+
+// Modified reports if a file loaded while compiling changed since it was loaded.
+// The modification time of every file is checked through the loader, loaders not
+// reporting modification times is never modified.
+func (this *CompiledTemplate) Modified(loader TemplateLoader) bool {
+	for _, load := range this.loads {
+		modtime, ok, err := templateModTime(loader, load.name, load.layer)
+		if ok && (err != nil || !modtime.Equal(load.template.ModTime)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns the warnings found while parsing the templates loaded by Compile.
+func (this *CompiledTemplate) Warnings() []*Warning {
+	result := make([]*Warning, 0)
+	for _, load := range this.loads {
+		if load.template.IsJade {
+			result = append(result, load.template.Root.Warnings...)
+		}
+	}
+	return result
+}
+
+// addParts adds the mixins and blocks of a template. Mixins and blocks
+// already defined by a template lower in the extends chain are kept.
+func (this *CompiledTemplate) addParts(template *Template) {
+	result := template.Root
+	for k, v := range result.Mixins {
+		if _, ok := this.Mixins[k]; !ok {
+			this.Mixins[k] = &jadePart{template.Name, v, template.File, template.Layer}
+		}
+	}
+	for k, v := range result.Blocks {
+		if _, ok := this.Blocks[k]; !ok {
+			this.Blocks[k] = &jadePart{template.Name, v, template.File, template.Layer}
+		}
+	}
+}
+
+func newStackFrame(kind string, name string, from *Template, node *TreeNode) StackFrame {
+	frame := StackFrame{Kind: kind, Name: name, Template: from.Name}
+	if node != nil {
+		frame.Line = LineNumber(string(from.File), node.Pos)
+	}
+	return frame
+}
+
+// findIncludes returns the include statements in the tree.
+func findIncludes(node *TreeNode) []*TreeNode {
+	result := make([]*TreeNode, 0)
+	if fn, ok := node.Value.(*FuncToken); ok && fn.Name == "include" && len(fn.Arguments) > 0 {
+		if _, ok := fn.Arguments[0].Value.(*TextToken); ok {
+			result = append(result, node)
+		}
+	}
+	for _, item := range node.Items() {
+		result = append(result, findIncludes(item)...)
+	}
+	return result
+}
+
+// findExtends returns the extends statement of a template.
+func findExtends(root *TreeNode) *TreeNode {
+	for _, item := range root.Items() {
+		if fn, ok := item.Value.(*FuncToken); ok && fn.Name == "extends" {
+			return item
+		}
+	}
+	return nil
+}
+
+// Dependencies lists the files a compiled template depends on.
+type Dependencies struct {
+	Name string
+	//Extends is the extends chain, starting with the template extended by the compiled template.
+	Extends []string
+	//Includes is the files included by the templates in the extends chain, including nested includes.
+	Includes []string
+	//Mixins contains the templates defining each mixin. The mixin of the first template is used.
+	Mixins map[string][]string
+	//Blocks contains the templates defining each block. The block of the first template is used.
+	Blocks map[string][]string
+}
+
+// Dependencies returns the files the template depends on, and where each mixin
+// and block is defined.
+func (this *CompiledTemplate) Dependencies() *Dependencies {
+	deps := &Dependencies{
+		Name:     this.Name,
+		Extends:  make([]string, 0),
+		Includes: make([]string, 0),
+		Mixins:   make(map[string][]string),
+		Blocks:   make(map[string][]string),
+	}
+	for _, template := range this.Chain[1:] {
+		deps.Extends = append(deps.Extends, template.Name)
+	}
+	for _, load := range this.loads {
+		if !this.inChain(load.template) {
+			deps.Includes = append(deps.Includes, load.template.Name)
+		}
+	}
+	//mixins and blocks of the extends chain is used before the mixins and blocks of included files.
+	for _, template := range this.Chain {
+		deps.addParts(template)
+	}
+	for _, load := range this.loads {
+		if !this.inChain(load.template) {
+			deps.addParts(load.template)
+		}
+	}
+	return deps
+}
+
+func (this *Dependencies) addParts(template *Template) {
+	if !template.IsJade {
+		return
+	}
+	for name := range template.Root.Mixins {
+		this.Mixins[name] = append(this.Mixins[name], template.Name)
+	}
+	for name := range template.Root.Blocks {
+		this.Blocks[name] = append(this.Blocks[name], template.Name)
+	}
+}
+
+// Files returns the extends chain followed by the included files.
+func (this *Dependencies) Files() []string {
+	return append(append([]string{}, this.Extends...), this.Includes...)
+}
+
+func (this *CompiledTemplate) inChain(template *Template) bool {
+	for _, t := range this.Chain {
+		if t == template {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package jadeparser
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html"
@@ -21,6 +22,8 @@ var TreeNodeType reflect.Type = reflect.TypeOf(new(TreeNode))
 var LinearMapType reflect.Type = reflect.TypeOf(new(LinearMap))
 var EmptyString = reflect.ValueOf("")
 var nilValueType = reflect.TypeOf(nilValue{})
+var stringType = reflect.TypeOf("")
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 // createValueFuncs turns a FuncMap into a map[string]reflect.Value
 func createValueFuncs(funcMap funcMap) map[string]reflect.Value {
@@ -53,32 +56,148 @@ func registerFunction(addto map[string]reflect.Value, name string, fn interface{
 	}
 }
 
+// warning reports a problem found at the node that does not stop rendering. If node is nil
+// the node currently routed is used.
 func (this *EvalJade) warning(warning string, node *TreeNode, args ...interface{}) {
-	this.Log = append(this.Log, fmt.Sprintf("Warning: "+warning, args...))
+	message := fmt.Sprintf(warning, args...)
+	this.Log = append(this.Log, "Warning: "+message)
+	if this.WarningHandler == nil {
+		return
+	}
+	if node == nil {
+		node = this.node
+	}
+	result := &Warning{Message: message}
+	if part := this.currentPart(); part != nil && node != nil {
+		result = newWarning(part.Name, part.File, node.Pos, message)
+	}
+	this.WarningHandler(result)
+}
+
+// parseWarnings reports the warnings found while parsing a template.
+func (this *EvalJade) parseWarnings(template *Template) {
+	if this.WarningHandler == nil || !template.IsJade {
+		return
+	}
+	for _, warning := range template.Root.Warnings {
+		this.WarningHandler(warning)
+	}
 }
 
 // errorf formats the error and terminates processing.
 func (this *EvalJade) errorf(node *TreeNode, format string, args ...interface{}) {
-	err := ""
-	if this.currPart != nil {
-		err = fmt.Sprintf("Template %q ", this.currPart.Name)
+	err := this.newError(node, fmt.Errorf(format, args...))
+	for _, arg := range args {
+		if cause, ok := arg.(error); ok {
+			err.Err = cause
+		}
+	}
+	panic(err)
+}
+
+// newError creates a TemplateError located at the node in the template currently rendered.
+// If node is nil the node currently routed is used.
+func (this *EvalJade) newError(node *TreeNode, err error) *TemplateError {
+	if node == nil {
+		node = this.node
+	}
+	terr := &TemplateError{Message: err.Error()}
+	if part := this.currentPart(); part != nil {
+		terr.Name = part.Name
 		if node != nil {
-			err += fmt.Sprintf("Linenumber %v ", LineNumber(string(this.currPart.File), node.Pos))
+			terr.setPosition(part.File, node.Pos)
 		}
-	} else if this.currTemplate != nil {
-		err = fmt.Sprintf("Template %q ", this.currTemplate.Name)
+	}
+	terr.Stack = make([]StackFrame, len(this.callstack))
+	copy(terr.Stack, this.callstack)
+	return terr
+}
+
+// recover turns a panic into a error located in the template, stopping the render.
+// recover must be deferred by the public render methods.
+func (this *EvalJade) recover(errp *error) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	var err error
+	switch val := e.(type) {
+	case error:
+		err = val
+	default:
+		err = fmt.Errorf("%v", e)
+	}
+	if this.ctx != nil && err == this.ctx.Err() {
+		//a canceled render returns the context error as is.
+		*errp = err
+		return
+	}
+	if _, ok := err.(*WriteError); ok {
+		*errp = err
+		return
+	}
+	var terr *TemplateError
+	if !errors.As(err, &terr) {
+		terr = this.newError(nil, err)
+		terr.Err = err
+		err = terr
+	}
+	*errp = err
+}
+
+// checkContext stops rendering when the render context is canceled or past its deadline.
+func (this *EvalJade) checkContext() {
+	if this.ctx == nil {
+		return
+	}
+	if err := this.ctx.Err(); err != nil {
+		panic(err)
+	}
+}
+
+// getContext returns the render context, passed to functions with a context.Context first parameter.
+func (this *EvalJade) getContext() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
+// flushOutput writes the buffered output to the writer when rendering is done.
+func (this *EvalJade) flushOutput(errp *error) {
+	if err := this.writer.buf.Flush(); err != nil && *errp == nil {
+		*errp = &WriteError{err}
+	}
+}
+
+// currentPart returns the block, mixin or template currently rendered.
+func (this *EvalJade) currentPart() *jadePart {
+	if this.currPart != nil {
+		return this.currPart
+	}
+	if this.currTemplate != nil {
+		return &jadePart{this.currTemplate.Name, nil, this.currTemplate.File, this.currTemplate.Layer}
+	}
+	return nil
+}
+
+// pushCall adds a extends, include, block or mixin call made from node to the call stack.
+// The call is not removed if the render is stopped by an error, leaving the
+// stack intact for the error.
+func (this *EvalJade) pushCall(kind string, name string, node *TreeNode) {
+	this.checkDepth(kind, name)
+	frame := StackFrame{Kind: kind, Name: name}
+	if part := this.currentPart(); part != nil {
+		frame.Template = part.Name
 		if node != nil {
-			err += fmt.Sprintf("Linenumber %v ", LineNumber(string(this.currTemplate.File), node.Pos))
+			frame.Line = LineNumber(string(part.File), node.Pos)
 		}
 	}
-	//	name := doublePercent(s.tmpl.Name())
-	//	if s.node == nil {
-	//		format = fmt.Sprintf("template: %s: %s", name, format)
-	//	} else {
-	//		location, context := s.tmpl.ErrorContext(s.node)
-	//		format = fmt.Sprintf("template: %s: executing %q at <%s>: %s", location, name, doublePercent(context), format)
-	//	}
-	panic(fmt.Errorf(err+format, args...))
+	this.callstack = append(this.callstack, frame)
+}
+
+func (this *EvalJade) popCall() {
+	this.callstack = this.callstack[:len(this.callstack)-1]
 }
 
 func LineNumber(source string, pos int) int {
@@ -91,6 +210,10 @@ func LineNumber(source string, pos int) int {
 
 func (this *EvalJade) router(node *TreeNode) {
 	writer := this.writer
+	//the previous node is not restored when a panic occurs, so errors are located at the inner most node.
+	prevnode := this.node
+	this.node = node
+	this.step(node)
 	switch val := node.Value.(type) {
 	case *EmptyToken:
 		this.evalContent(node)
@@ -113,12 +236,16 @@ func (this *EvalJade) router(node *TreeNode) {
 	case *CommentToken:
 		writer.Comment(node, val)
 	}
+	this.node = prevnode
 }
 
 func (this *EvalJade) getValue(node *TreeNode) reflect.Value {
 	if node == nil {
 		return EmptyString
 	}
+	if node.value != nil && this.usePlan() {
+		return node.value(this)
+	}
 	var result interface{}
 	switch val := node.Value.(type) {
 	case *NumberToken:
@@ -142,7 +269,11 @@ func (this *EvalJade) getValue(node *TreeNode) reflect.Value {
 func (this *EvalJade) getValueAs(node *TreeNode, argtype reflect.Type) (reflect.Value, error) {
 	value := this.getValue(node)
 	rvalue := toReflectValue(value)
-	return this.validateType(rvalue, argtype)
+	if argtype.Kind() == reflect.Interface && argtype.NumMethod() == 0 && rvalue.CanInterface() {
+		//functions with interface{} parameters, like the operators, expects numbers as float64.
+		rvalue = toCommonType(this.Sandbox.value(rvalue))
+	}
+	return validateType(rvalue, argtype)
 }
 
 func (this *EvalJade) getGroup(node *TreeNode, group *GroupToken) reflect.Value {
@@ -217,7 +348,7 @@ func (this *EvalJade) jadeBlock(node *TreeNode, blockfn *FuncToken) {
 	if len(blockname) == 0 {
 		//mixin block has no name an is stored in the stack.
 		if blockvar, ok := this.stack.GetOk("block"); ok {
-			block = &jadePart{"", blockvar.Interface().(*TreeNode), this.currTemplate.File}
+			block = blockvar.Interface().(*jadePart)
 		}
 	} else {
 		//normal page blocks has a name and is stored in the page
@@ -226,9 +357,12 @@ func (this *EvalJade) jadeBlock(node *TreeNode, blockfn *FuncToken) {
 	if block == nil {
 		this.errorf(node, "Block %q not found.", blockname)
 	}
+	prevpart := this.currPart
+	this.pushCall("block", blockname, node)
 	this.currPart = block
 	this.evalContent(block.Part)
-	this.currPart = nil
+	this.currPart = prevpart
+	this.popCall()
 }
 
 func (this *EvalJade) jadeMixin(val *TreeNode, token *FuncToken) string {
@@ -240,42 +374,83 @@ func (this *EvalJade) jadeMixin(val *TreeNode, token *FuncToken) string {
 	if !ok {
 		this.errorf(val, "Mixin %q not found.", fn.Name)
 	}
-	this.currPart = mixindef
-	this.stack.AddLayer()
-	defer this.stack.DropLayer()
+	caller := this.currentPart()
+	this.pushCall("mixin", fn.Name, val)
 
-	//set context
-	mixinfn := mixindef.Part
-	if fnplaceholder, ok := mixinfn.Value.(*FuncToken); ok {
-		if fndef, ok := fnplaceholder.Arguments[0].Value.(*FuncToken); ok && !fndef.IsIdentity {
-			for i, arg := range fndef.Arguments {
-				argidentity := arg.Value.(*FuncToken)
-				this.stack.Set(argidentity.Name, this.getValue(fn.Arguments[i]))
-			}
-			if fn.Next != nil && fn.Next.Name == "attributes" {
-				attributes := NewGroupToken("{}")
-				node := NewTreeNode(attributes)
-				for _, v := range fn.Next.Arguments {
-					if op, ok := v.Value.(*OperatorToken); ok && op.Operator == "=" {
-						key := v.Items()[0].Value.(*FuncToken).Name
-						node.AddElement(NewTreeNode(NewKeyValueToken(key, v.Items()[1])))
-					} else {
-						panic("Expecting Key Value pairs seperated by '=' found '" + v.String() + "'")
-					}
+	//arguments and attributes is only bound when the mixin is declared with parameters.
+	var args []reflect.Value
+	var attributes reflect.Value
+	if params, ok := mixinParams(mixindef); ok {
+		for i := 0; i < len(params) && i < len(fn.Arguments); i++ {
+			args = append(args, this.getValue(fn.Arguments[i]))
+		}
+		if fn.Next != nil && fn.Next.Name == "attributes" {
+			group := NewGroupToken("{}")
+			node := NewTreeNode(group)
+			for _, v := range fn.Next.Arguments {
+				if op, ok := v.Value.(*OperatorToken); ok && op.Operator == "=" {
+					key := v.Items()[0].Value.(*FuncToken).Name
+					node.AddElement(NewTreeNode(NewKeyValueToken(key, v.Items()[1])))
+				} else {
+					panic("Expecting Key Value pairs seperated by '=' found '" + v.String() + "'")
 				}
-				this.stack.Set("attributes", this.getGroup(node, attributes))
 			}
+			attributes = this.getGroup(node, group)
 		}
 	}
 	//set block
+	var block *jadePart
 	if len(val.Items()) > 0 {
-		this.stack.Set("block", val)
+		block = &jadePart{caller.Name, val, caller.File, caller.Layer}
 	}
-	this.evalContent(mixinfn)
-	this.currPart = nil
+	this.callMixin(fn.Name, mixindef, args, attributes, block)
+	this.popCall()
 	return ""
 }
 
+// callMixin renders a mixin with the arguments bound to the mixin parameters. A mixin
+// declared with parameters receives empty attributes when attributes is not valid.
+func (this *EvalJade) callMixin(name string, mixindef *jadePart, args []reflect.Value, attributes reflect.Value, block *jadePart) {
+	params, ok := mixinParams(mixindef)
+	if len(args) < len(params) {
+		this.errorf(nil, "Mixin %q expects %v arguments, found %v.", name, len(params), len(args))
+	}
+	this.stack.AddLayer()
+	defer this.stack.DropLayer()
+	for i, param := range params {
+		this.stack.Set(param, args[i])
+	}
+	if ok && !attributes.IsValid() {
+		attributes = toReflectValue(&LinearMap{make(map[string]interface{}), make([]string, 0)})
+	}
+	if attributes.IsValid() {
+		this.stack.Set("attributes", attributes)
+	}
+	if block != nil {
+		this.stack.Set("block", block)
+	}
+	this.observe("mixin", name, func() {
+		prevpart := this.currPart
+		this.currPart = mixindef
+		this.evalContent(mixindef.Part)
+		this.currPart = prevpart
+	})
+}
+
+// mixinParams returns the parameter names of a mixin, and false if the mixin is declared without parameters.
+func mixinParams(mixindef *jadePart) ([]string, bool) {
+	if fnplaceholder, ok := mixindef.Part.Value.(*FuncToken); ok {
+		if fndef, ok := fnplaceholder.Arguments[0].Value.(*FuncToken); ok && !fndef.IsIdentity {
+			params := make([]string, len(fndef.Arguments))
+			for i, arg := range fndef.Arguments {
+				params[i] = arg.Value.(*FuncToken).Name
+			}
+			return params, true
+		}
+	}
+	return nil, false
+}
+
 func (this *EvalJade) jadeEach(node *TreeNode, fn *FuncToken) {
 	if len(fn.Arguments) != 3 {
 		this.errorf(node, "each statement invalid number of arguments, expecting at least 2. found %v", len(fn.Arguments))
@@ -310,29 +485,32 @@ func (this *EvalJade) iterValue(arrayValue reflect.Value, node *TreeNode, fn *Fu
 		this.errorf(node, "value '%s' after 'each in' not found. ", fn.Arguments[2])
 	}
 
-	switch arrayValue.Kind() {
+	switch arrayValue = toCommonType(arrayValue); arrayValue.Kind() {
 	case reflect.Array, reflect.Slice:
+		this.checkIterations(node, arrayValue.Len())
 		for i := 0; i < arrayValue.Len(); i++ {
 			itemvalue := arrayValue.Index(i)
 			if len(index) > 0 {
 				this.stack.Set(index, i)
 			}
-			this.stack.Set(ivalue, itemvalue)
+			this.stack.SetValue(ivalue, itemvalue)
 			this.evalContent(node)
 		}
 	case reflect.Map:
+		this.checkIterations(node, arrayValue.Len())
 		keys := arrayValue.MapKeys()
 		for i := 0; i < len(keys); i++ {
 			itemvalue := arrayValue.MapIndex(keys[i])
 			if len(index) > 0 {
 				this.stack.Set(index, keys[i])
 			}
-			this.stack.Set(ivalue, itemvalue)
+			this.stack.SetValue(ivalue, itemvalue)
 			this.evalContent(node)
 		}
 	case reflect.Ptr:
 		//handle iterating jsondata object
 		if val1, ok := array.(*LinearMap); ok {
+			this.checkIterations(node, len(val1.keys))
 			for _, k := range val1.keys {
 				if len(index) > 0 {
 					this.stack.Set(index, k)
@@ -345,7 +523,11 @@ func (this *EvalJade) iterValue(arrayValue reflect.Value, node *TreeNode, fn *Fu
 		this.iterValue(arrayValue.Elem(), node, fn, index, ivalue)
 		return
 	case reflect.Float64:
-		cnt := int(arrayValue.Float())
+		cnt, err := iterationCount(arrayValue.Float())
+		if err != nil {
+			this.errorf(node, "%v", err)
+		}
+		this.checkIterations(node, cnt)
 		for i := 0; i < cnt; i++ {
 			this.stack.Set(ivalue, i)
 			this.evalContent(node)
@@ -355,10 +537,14 @@ func (this *EvalJade) iterValue(arrayValue reflect.Value, node *TreeNode, fn *Fu
 	}
 }
 
-func (this *EvalJade) jadeInclude(fn *FuncToken) {
+func (this *EvalJade) jadeInclude(node *TreeNode, fn *FuncToken) {
 	if len(fn.Arguments) > 0 {
 		filename := this.getText(fn.Arguments[0])
-		this.RenderFile(filename)
+		prevtemplate, prevpart := this.currTemplate, this.currPart
+		this.pushCall("include", filename, node)
+		this.observe("include", filename, func() { this.evalFile(filename) })
+		this.currTemplate, this.currPart = prevtemplate, prevpart
+		this.popCall()
 	}
 }
 
@@ -367,19 +553,37 @@ func (this *EvalJade) writeText(text string) {
 }
 
 func (this *EvalJade) getText(node *TreeNode) string {
-	return ObjToString(this.getValue(node))
+	return this.valueText(this.getValue(node))
+}
+
+// valueToString returns the text of a value, see ObjToString. Numbers is written
+// without converting them to a interface{}, the same as numbers converted by toCommonType.
+func valueToString(val reflect.Value) string {
+	if !val.IsValid() {
+		return ""
+	}
+	switch val.Kind() {
+	case reflect.String:
+		if val.Type() == stringType {
+			return val.String()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(val.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'g', 15, 64)
+	}
+	if val.Type().AssignableTo(nilValueType) {
+		return ""
+	}
+	return ObjToString(val.Interface())
 }
 
 func ObjToString(val interface{}) string {
 	switch val2 := val.(type) {
 	case reflect.Value:
-		if !val2.IsValid() {
-			return ""
-		}
-		if val2.Type().AssignableTo(nilValueType) {
-			return ""
-		}
-		return ObjToString(val2.Interface())
+		return valueToString(val2)
 	case string:
 		return val2
 	case []interface{}:
@@ -420,52 +624,65 @@ func ObjToString(val interface{}) string {
 	}
 }
 
-func (this *EvalJade) getIdentityValue(node *TreeNode, token Token) (reflect.Value, bool) {
+// getIdentityValue returns the value of a variable, the members of the identities in the
+// chain is found through cache, nil when the identity is not in a execution plan.
+func (this *EvalJade) getIdentityValue(node *TreeNode, token Token, cache *memberCache) (reflect.Value, bool) {
 	var val1 reflect.Value
-	var err error
 	switch identity := token.(type) {
 	case *FuncToken:
 		if !identity.IsIdentity {
 			this.errorf(node, "Expecting a Variable Name on %v", identity.Name)
 		}
 		if sval, ok := this.stack.GetOk(identity.Name); ok {
-			val1 = this.findIdentityValue(node, sval, identity, true)
+			val1 = this.findIdentityValue(node, sval, identity, true, cache)
+		} else if gval, ok := this.getGlobal(identity.Name); ok {
+			val1 = this.findIdentityValue(node, gval, identity, true, cache)
 		} else {
-			val1 = this.findIdentityValue(node, this.data, identity, false)
+			val1 = this.findIdentityValue(node, this.data, identity, false, cache)
 		}
-		if err != nil {
-			switch err.(type) {
-			case VariableNotDefined:
-				this.warning("%s on %q", node, err.Error(), identity.String())
-				return reflect.Value{}, false
+		//block is tested with 'if block' in mixins, and is not defined when no block is passed.
+		if isUndefined(val1) && identity.Name != "block" {
+			if this.Strict {
+				this.errorf(node, "Variable %q not defined.", identity.String())
 			}
-			this.errorf(node, "Varaible Error '%s': %s", identity.String(), err.Error())
+			this.warning("Variable %q not defined.", node, identity.String())
 		}
 		if !val1.IsValid() {
 			return reflect.Value{}, false
 		}
-		if val1.CanInterface() {
-			return this.toCommonType(val1), true
-		} else {
-			//Just panic from interface(), until we find better way to handle error.
-			return val1, true
-		}
+		//numbers is converted to float64 when used as a argument, see getValueAs.
+		return val1, true
 	default:
 		this.errorf(node, "Unexpected token in identity field.")
 	}
 	return reflect.Value{}, false
 }
 
-func (this *EvalJade) findIdentityValue(node *TreeNode, rval reflect.Value, identity *FuncToken, got bool) reflect.Value {
+// getGlobal returns a global variable, if the variable is not defined on the data.
+func (this *EvalJade) getGlobal(name string) (reflect.Value, bool) {
+	value, ok := this.Globals[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	if !isNullValue(this.data) {
+		if _, err := this.getVariableValue(this.data, name, nil); err == nil {
+			return reflect.Value{}, false
+		}
+	}
+	return reflect.ValueOf(value), true
+}
+
+func (this *EvalJade) findIdentityValue(node *TreeNode, rval reflect.Value, identity *FuncToken, got bool, cache *memberCache) reflect.Value {
 	var mval reflect.Value = rval
 	var err, err2 error
 	if !got && len(identity.Name) > 0 {
 		if identity.IsIdentity {
-			mval, err2 = this.getVariableValue(rval, identity.Name)
+			mval, err2 = this.getVariableValue(rval, identity.Name, cache)
 		} else {
 			//if the identity item is a function call the function.
-			meth := rval.MethodByName(identity.Name)
+			meth := methodByName(rval, identity.Name, cache)
 			if meth.IsValid() {
+				this.checkMember(node, rval, identity.Name, true)
 				mval, err2 = this.callFunc(meth, identity.Name, identity.Arguments)
 			} else {
 				this.errorf(node, "function %s not found on struct %v", identity.Name, rval.Kind())
@@ -475,26 +692,26 @@ func (this *EvalJade) findIdentityValue(node *TreeNode, rval reflect.Value, iden
 	if identity.Index != nil {
 		if err2 != nil {
 			//Only Raise the nil ref error, if another operation is done on this value.
-			this.errorf(node, err2.Error())
+			this.errorf(node, "%v", err2)
 		}
 		index := this.getText(identity.Index)
-		mval, err = this.getVariableValue(mval, index)
+		mval, err = this.getVariableValue(mval, index, nil)
 	}
 	if identity.Next != nil {
 		if err2 != nil {
 			//Only Raise the nil ref error, if another operation is done on this value.
-			this.errorf(node, err2.Error())
+			this.errorf(node, "%v", err2)
 		}
-		mval = this.findIdentityValue(node, mval, identity.Next, false)
+		mval = this.findIdentityValue(node, mval, identity.Next, false, cache.nextCache())
 		err = nil
 	}
 	if err != nil {
-		this.errorf(node, err.Error())
+		this.errorf(node, "%v", err)
 	}
 	return mval
 }
 
-func (this *EvalJade) getVariableValue(rval reflect.Value, name string) (result reflect.Value, err error) {
+func (this *EvalJade) getVariableValue(rval reflect.Value, name string, cache *memberCache) (result reflect.Value, err error) {
 	if !rval.IsValid() {
 		//err = fmt.Errorf("Invalid Variable. '%s'", name)
 		result = newNilValue(name, "Parent Object nil")
@@ -526,10 +743,11 @@ func (this *EvalJade) getVariableValue(rval reflect.Value, name string) (result
 		result = toBasicReflectValue(result, name)
 		return
 	case reflect.Struct:
-		result = rval.FieldByName(name)
-		if !result.IsValid() {
+		result, err = fieldByName(rval, name, cache)
+		if err != nil {
 			result = newNilValue(name, "Variable Not Defined")
-			err = VariableNotDefined{fmt.Errorf("Variable %q not defined on struct.", name)}
+		} else {
+			this.checkMember(nil, rval, name, false)
 		}
 		result = toBasicReflectValue(result, name)
 		return
@@ -547,7 +765,7 @@ func (this *EvalJade) getVariableValue(rval reflect.Value, name string) (result
 			}
 		}
 		//Other Pointers
-		return this.getVariableValue(rval.Elem(), name)
+		return this.getVariableValue(rval.Elem(), name, cache)
 	default:
 		//Handle LinearMap struct
 		if rval.Type() == LinearMapType {
@@ -567,44 +785,73 @@ func (this *EvalJade) getVariableValue(rval reflect.Value, name string) (result
 	}
 }
 
-func (this *EvalJade) toCommonType(val1 reflect.Value) reflect.Value {
-	var float float64
+// toCommonType returns numbers as float64, the type of numbers in templates.
+func toCommonType(val1 reflect.Value) reflect.Value {
 	switch val1.Kind() {
 	case reflect.String, reflect.Bool, reflect.Float64:
 		return val1
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return val1.Convert(reflect.TypeOf(float))
+		return val1.Convert(float64Type)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return val1.Convert(reflect.TypeOf(float))
+		return val1.Convert(float64Type)
 	case reflect.Float32:
-		return val1.Convert(reflect.TypeOf(float))
+		return val1.Convert(float64Type)
 	default:
 		return val1
 	}
 }
 
-func (this *EvalJade) findFunction(name string) reflect.Value {
-	//first check the model class
+func (this *EvalJade) findFunction(node *TreeNode, name string) reflect.Value {
+	//first check the model class, methods not allowed by the sandbox is skipped.
+	var meth reflect.Value
 	if this.data.IsValid() && this.data.NumMethod() > 0 {
-		meth := this.data.MethodByName(name)
-		if meth.IsValid() {
+		meth = methodByName(this.data, name, nil)
+		if meth.IsValid() && this.Sandbox.AllowMember(this.data.Type(), name, true) {
 			return meth
 		}
 	}
 	//now check for registered functions.
 	fn, ok := this.builtin[name]
+	if ok {
+		return fn
+	}
+	fn, ok = this.Localfunc[name]
 	if !ok {
 		fn, ok = this.Extfunc[name]
-		if !ok {
-			panic(fmt.Errorf("Function %q not found.", name))
+	}
+	if !ok {
+		if meth.IsValid() {
+			this.checkMember(node, this.data, name, true)
 		}
+		this.errorf(node, "Function %q not found.", name)
 	}
+	this.checkFunction(node, name)
 	return fn
 }
 
+// isFunction returns true when the data has a method, or a function is registered with the name.
+func (this *EvalJade) isFunction(name string) bool {
+	if this.data.IsValid() && methodByName(this.data, name, nil).IsValid() {
+		return true
+	}
+	if _, ok := this.builtin[name]; ok {
+		return true
+	}
+	if _, ok := this.Localfunc[name]; ok {
+		return true
+	}
+	_, ok := this.Extfunc[name]
+	return ok
+}
+
 func (this *EvalJade) evalContent(node *TreeNode) {
+	if node.content != nil && this.usePlan() {
+		node.content(this)
+		return
+	}
 	var ifresult int
 	for _, item := range node.Items() {
+		this.checkContext()
 		//handle if statements
 		fntoken, ok := item.Value.(*FuncToken)
 		if ok && (fntoken.Name == "if" || fntoken.Name == "unless") {
@@ -675,7 +922,7 @@ func (this *EvalJade) evalOperator(node *TreeNode, token *OperatorToken) reflect
 	case "?":
 		return this.conditional(node)
 	}
-	fn := this.findFunction(token.Operator)
+	fn := this.findFunction(node, token.Operator)
 	val1, err := this.callFunc(fn, token.Operator, node.items)
 	if err != nil {
 		this.errorf(node, "Error on operator %q Error: %v", token.Operator, err)
@@ -685,7 +932,7 @@ func (this *EvalJade) evalOperator(node *TreeNode, token *OperatorToken) reflect
 
 func (this *EvalJade) evalFunc(node *TreeNode, token *FuncToken) reflect.Value {
 	if token.IsIdentity {
-		val1, _ := this.getIdentityValue(node, token)
+		val1, _ := this.getIdentityValue(node, token, nil)
 		return val1
 	}
 	switch token.Name {
@@ -713,13 +960,24 @@ func (this *EvalJade) evalFunc(node *TreeNode, token *FuncToken) reflect.Value {
 		this.jadeBlock(node, token)
 		return EmptyString
 	case "include":
-		this.jadeInclude(token)
+		this.jadeInclude(node, token)
 		return EmptyString
 	case "extends":
 		return EmptyString
+	case "flush":
+		//flush is a function call when the data has a method or a function is registered with the name.
+		if !this.isFunction(token.Name) {
+			this.writer.flush()
+			return EmptyString
+		}
+	}
+	fn := this.findFunction(node, token.Name)
+	var event *Event
+	if _, ok := this.builtin[token.Name]; !ok {
+		event = this.beforeEvent("function", token.Name)
 	}
-	fn := this.findFunction(token.Name)
 	val1, err := this.callFunc(fn, token.Name, token.Arguments)
+	this.afterEvent(event, err)
 	if err != nil {
 		this.errorf(node, "External function %q Error: %v", token.Name, err)
 	}
@@ -727,35 +985,64 @@ func (this *EvalJade) evalFunc(node *TreeNode, token *FuncToken) reflect.Value {
 }
 
 func (this *EvalJade) evalFile(filename string) *Template {
-	template := this.Loader.Load(filename)
+	template := this.load(filename)
 	if template.IsJade {
+		this.enterFile(template)
+		defer this.exitFile()
 		this.buildJadeFromParseResult(template)
 		if len(template.Root.Extends) > 0 {
-			return this.evalFile(template.Root.Extends)
+			this.currTemplate, this.currPart = template, nil
+			this.pushCall("extends", template.Root.Extends, findExtends(template.Root.Root))
+			var result *Template
+			this.observe("extends", template.Root.Extends, func() { result = this.evalFile(template.Root.Extends) })
+			this.popCall()
+			return result
 		}
-		this.currTemplate = template
-		this.Exec(template.Root.Root)
+		this.currTemplate, this.currPart = template, nil
+		this.router(template.Root.Root)
 	} else {
 		this.writeText(string(template.File))
 	}
 	return template
 }
 
+// load returns a template compiled ahead of time, or loads it through the Loader.
+func (this *EvalJade) load(filename string) *Template {
+	layer := 0
+	if part := this.currentPart(); part != nil {
+		layer = part.Layer
+	}
+	if template, ok := this.templates[templateKey(filename, layer)]; ok {
+		return template
+	}
+	template, err := loadTemplate(this.Loader, filename, layer)
+	if err != nil {
+		panic(err)
+	}
+	this.parseWarnings(template)
+	return template
+}
+
 // callFunc executes a function or method call. If it's a method, fun already has the receiver bound, so
 // it looks just like a function call.  The arg list, if non-nil, includes (in the manner of the shell), arg[0]
 // as the function itself.
 func (s *EvalJade) callFunc(fun reflect.Value, name string, args []*TreeNode) (result reflect.Value, err error) {
 	defer errRecover(&err)
 	typ := fun.Type()
-	numIn := len(args)
-	numFixed := len(args)
+	// A context.Context first parameter receives the render context and is not passed from the template.
+	skip := 0
+	if typ.NumIn() > 0 && typ.In(0) == contextType {
+		skip = 1
+	}
+	numIn := len(args) + skip
+	numFixed := numIn
 	if typ.IsVariadic() {
 		numFixed = typ.NumIn() - 1 // last arg is the variadic one.
 		if numIn < numFixed {
-			err = fmt.Errorf("wrong number of args for %s: want at least %d got %d", name, typ.NumIn()-1, len(args))
+			err = fmt.Errorf("wrong number of args for %s: want at least %d got %d", name, typ.NumIn()-1-skip, len(args))
 		}
 	} else if numIn < typ.NumIn()-1 || !typ.IsVariadic() && numIn != typ.NumIn() {
-		err = fmt.Errorf("wrong number of args for %s: want %d got %d %v", name, typ.NumIn(), len(args), args)
+		err = fmt.Errorf("wrong number of args for %s: want %d got %d %v", name, typ.NumIn()-skip, len(args), args)
 	}
 	if !goodFunc(typ) {
 		// TODO: This could still be a confusing error; maybe goodFunc should provide info.
@@ -763,10 +1050,13 @@ func (s *EvalJade) callFunc(fun reflect.Value, name string, args []*TreeNode) (r
 	}
 	// Build the arg list.
 	argv := make([]reflect.Value, numIn)
+	if skip > 0 {
+		argv[0] = reflect.ValueOf(s.getContext())
+	}
 	// Args must be evaluated. Fixed args first.
-	i := 0
-	for ; i < numFixed && i < len(args); i++ {
-		argv[i], err = s.getValueAs(args[i], typ.In(i))
+	i := skip
+	for ; i < numFixed && i < numIn; i++ {
+		argv[i], err = s.getValueAs(args[i-skip], typ.In(i))
 		if err != nil {
 			err = fmt.Errorf("Argument %q %v", typ.In(i).Name(), err)
 			return
@@ -775,8 +1065,8 @@ func (s *EvalJade) callFunc(fun reflect.Value, name string, args []*TreeNode) (r
 	// Now the ... args.
 	if typ.IsVariadic() {
 		argType := typ.In(typ.NumIn() - 1).Elem() // Argument is a slice.
-		for ; i < len(args); i++ {
-			argv[i], err = s.getValueAs(args[i], argType)
+		for ; i < numIn; i++ {
+			argv[i], err = s.getValueAs(args[i-skip], argType)
 			if err != nil {
 				err = fmt.Errorf("Argument %q %v", argType.Name(), err)
 				return
@@ -787,7 +1077,7 @@ func (s *EvalJade) callFunc(fun reflect.Value, name string, args []*TreeNode) (r
 	// If we have an error that is not nil, stop execution and return that error to the caller.
 	if len(fnresult) == 2 && !fnresult[1].IsNil() {
 		//s.at(node)
-		err = fmt.Errorf("error calling %s: %s", name, fnresult[1].Interface().(error))
+		err = fmt.Errorf("error calling %s: %w", name, fnresult[1].Interface().(error))
 	}
 	result = fnresult[0]
 	return
@@ -867,7 +1157,7 @@ func basicKind(v reflect.Value) (kind, error) {
 }
 
 // validateType guarantees that the value is valid and assignable to the type.
-func (s *EvalJade) validateType(value reflect.Value, typ reflect.Type) (result reflect.Value, err error) {
+func validateType(value reflect.Value, typ reflect.Type) (result reflect.Value, err error) {
 	result = value
 	if !value.IsValid() {
 		if typ == nil || canBeNil(typ) {
@@ -895,7 +1185,7 @@ func (s *EvalJade) validateType(value reflect.Value, typ reflect.Type) (result r
 			// fallthrough
 		}
 		//		if typ.Kind() == reflect.Float64 {
-		//			return s.toCommonType(value)
+		//			return toCommonType(value)
 		//		}
 		// Does one dereference or indirection work? We could do more, as we
 		// do with method receivers, but that gets messy and method receivers
@@ -1000,6 +1290,18 @@ func newNilValue(name string, reason string) reflect.Value {
 	return reflect.ValueOf(nilValue{name, reason})
 }
 
+// isUndefined reports if a value is a variable not defined on the data.
+func isUndefined(value reflect.Value) bool {
+	if !value.IsValid() || value.Type() != nilValueType {
+		return false
+	}
+	switch value.Interface().(nilValue).Reason {
+	case "Variable Not Found", "Variable Not Defined", "Not Found on Object.", "Parent Object nil":
+		return true
+	}
+	return false
+}
+
 func isNullValue(value reflect.Value) bool {
 	if !value.IsValid() {
 		return true
@@ -1,8 +1,11 @@
 package jadeparser
 
 import (
+	"bufio"
+	"context"
 	"io"
 	"reflect"
+	"sort"
 )
 
 type EvalJade struct {
@@ -12,19 +15,37 @@ type EvalJade struct {
 	data         reflect.Value
 	builtin      map[string]reflect.Value
 	Extfunc      map[string]reflect.Value
+	Localfunc    map[string]reflect.Value //Functions for a single render, found before Extfunc.
+	Globals      map[string]interface{}   //Variables available to every template, shadowed by the data.
+	funcs        map[string]reflect.Value //Functions found by name while rendering, see boundFunction.
 	writer       *jadewriter
 	doctype      string
 	stack        *ContextStack
 	Blocks       map[string]*jadePart
 	Mixins       map[string]*jadePart
 	Beautify     bool
+	Strict       bool //Undefined variables and members of nil values is an error instead of a warning.
 	Log          []string
+	templates    map[string]*Template //Templates loaded by Compile, checked before the Loader.
+	callstack    []StackFrame
+	node         *TreeNode //The node currently routed, used to locate errors.
+	ctx          context.Context
+	Limits       Limits
+	Observers    []Observer
+	Sandbox      *Sandbox //Restricts the functions, methods and fields a template can use, nil for no restrictions.
+	FlushTags    []string //Output is flushed after the closing tag of these tags, and at the flush keyword. Example: []string{"head"}
+	steps        int      //Number of nodes evaluated, see Limits.MaxSteps.
+	iterations   int      //Number of loop iterations, see Limits.MaxIterations.
+	files        []string //Path of the templates currently rendered, used to detect include and extends cycles.
+	treeWalk     bool     //Render by walking the tree instead of the execution plan, used to compare the two.
+	//WarningHandler is called for each warning found while rendering, like undefined variables.
+	WarningHandler func(warning *Warning)
 }
 
 func NewEvalJade(wr io.Writer) *EvalJade {
 	eval := new(EvalJade)
 	eval.Loader = new(templateLoader)
-	eval.writer = &jadewriter{wr, eval, false}
+	eval.writer = &jadewriter{wr: wr, buf: bufio.NewWriter(wr), template: eval}
 	eval.builtin = createValueFuncs(builtin)
 	eval.Extfunc = make(map[string]reflect.Value)
 	eval.registerStandardFunctions()
@@ -37,6 +58,24 @@ func NewEvalJade(wr io.Writer) *EvalJade {
 
 func (this *EvalJade) SetData(data interface{}) {
 	this.data = reflect.ValueOf(data)
+	this.funcs = nil
+}
+
+// SetLocal sets a variable on the outer most scope of the template. Locals shadow the data.
+func (this *EvalJade) SetLocal(name string, value interface{}) {
+	this.stack.SetGlobal(name, toReflectValue(value))
+}
+
+// SetDoctype sets the doctype used to render tags, until a doctype statement is found.
+// Example: SetDoctype("html") renders self closing tags as <br>
+func (this *EvalJade) SetDoctype(doctype string) {
+	this.doctype = doctype
+}
+
+// SetContext sets the context of the render. Rendering stops with ctx.Err() when the
+// context is canceled, and functions with a context.Context first parameter receives ctx.
+func (this *EvalJade) SetContext(ctx context.Context) {
+	this.ctx = ctx
 }
 
 func (this *EvalJade) SetViewPath(viewpath string) {
@@ -50,35 +89,172 @@ func (this *EvalJade) buildJadeFromParseResult(template *Template) {
 	}
 	for k, v := range result.Mixins {
 		if _, ok := this.Mixins[k]; !ok {
-			this.Mixins[k] = &jadePart{template.Name, v, template.File}
+			this.Mixins[k] = &jadePart{template.Name, v, template.File, template.Layer}
 		}
 	}
 	for k, v := range result.Blocks {
 		if _, ok := this.Blocks[k]; !ok {
-			this.Blocks[k] = &jadePart{template.Name, v, template.File}
+			this.Blocks[k] = &jadePart{template.Name, v, template.File, template.Layer}
 		}
 	}
 }
 
-func (this *EvalJade) Exec(parsedJade *TreeNode) {
+// Exec renders a parsed jade tree.
+func (this *EvalJade) Exec(parsedJade *TreeNode) (err error) {
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
 	this.router(parsedJade)
+	return
 }
 
 func (this *EvalJade) RegisterFunction(name string, fn interface{}) {
 	registerFunction(this.Extfunc, name, fn)
+	this.funcs = nil
 }
 
-func (this *EvalJade) RenderFile(filename string) {
+// RenderFile loads a jade file through the Loader and renders it.
+func (this *EvalJade) RenderFile(filename string) (err error) {
+	defer this.afterRender(this.beforeEvent("render", filename), &err)
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
 	this.evalFile(filename)
+	return
+}
+
+// Execute renders a compiled template. The compiled template is not modified,
+// so it can be executed by several EvalJade instances at the same time.
+func (this *EvalJade) Execute(compiled *CompiledTemplate) (err error) {
+	defer this.afterRender(this.beforeEvent("render", compiled.Name), &err)
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
+	this.prepare(compiled)
+	this.executeChain(compiled, 0)
+	return
+}
+
+// executeChain renders the templates of the extends chain from index i, each template
+// extending the next is reported to the observers the same as when the file is rendered.
+func (this *EvalJade) executeChain(compiled *CompiledTemplate, i int) {
+	if i < len(compiled.Chain)-1 {
+		template := compiled.Chain[i]
+		this.currTemplate, this.currPart = template, nil
+		this.pushCall("extends", template.Root.Extends, findExtends(template.Root.Root))
+		this.observe("extends", template.Root.Extends, func() { this.executeChain(compiled, i+1) })
+		this.popCall()
+		return
+	}
+	root := compiled.Root
+	if !root.IsJade {
+		this.writeText(string(root.File))
+		return
+	}
+	this.currTemplate = root
+	this.router(root.Root.Root)
+}
+
+// ExecuteBlock renders a single block of a compiled template. The block is found the
+// same way as when the template is executed, the block lowest in the extends chain is used.
+func (this *EvalJade) ExecuteBlock(compiled *CompiledTemplate, name string) (err error) {
+	defer this.afterRender(this.beforeEvent("render", compiled.Name), &err)
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
+	this.prepare(compiled)
+	this.prepareIncludes(compiled)
+	this.currTemplate = compiled.Chain[0]
+	block, ok := this.Blocks[name]
+	if !ok {
+		this.errorf(nil, "Block %q not found.", name)
+	}
+	this.pushCall("block", name, nil)
+	this.currPart = block
+	this.evalContent(block.Part)
+	this.popCall()
+	return
+}
+
+// ExecuteMixin renders a mixin of a compiled template, with the arguments and attributes
+// bound the same as when the mixin is called from a template. The attributes is passed
+// to the mixin in the order of the sorted keys.
+func (this *EvalJade) ExecuteMixin(compiled *CompiledTemplate, name string, args []interface{}, attributes map[string]interface{}) (err error) {
+	defer this.afterRender(this.beforeEvent("render", compiled.Name), &err)
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
+	this.prepare(compiled)
+	this.prepareIncludes(compiled)
+	this.currTemplate = compiled.Chain[0]
+	mixin, ok := this.Mixins[name]
+	if !ok {
+		this.errorf(nil, "Mixin %q not found.", name)
+	}
+	this.pushCall("mixin", name, nil)
+	values := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		values[i] = toCommonType(toBasicReflectValue(reflect.ValueOf(arg), ""))
+	}
+	var attrvalue reflect.Value
+	if _, ok := mixinParams(mixin); ok && attributes != nil {
+		keys := make([]string, 0, len(attributes))
+		for k := range attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		attrmap := &LinearMap{make(map[string]interface{}), make([]string, 0)}
+		for _, k := range keys {
+			if attributes[k] == nil {
+				attrmap.Set(k, nil)
+			} else {
+				attrmap.Set(k, toCommonType(reflect.ValueOf(attributes[k])).Interface())
+			}
+		}
+		attrvalue = toReflectValue(attrmap)
+	}
+	this.callMixin(name, mixin, values, attrvalue, nil)
+	this.popCall()
+	return
+}
+
+// prepareIncludes adds the blocks and mixins of included files. Blocks and mixins of
+// included files is added when the file is included, a block or mixin rendered on its
+// own is rendered without the templates including the files, so they are added first.
+func (this *EvalJade) prepareIncludes(compiled *CompiledTemplate) {
+	for _, load := range compiled.loads {
+		if load.template.IsJade && !compiled.inChain(load.template) {
+			this.buildJadeFromParseResult(load.template)
+		}
+	}
+}
+
+// prepare sets the templates, blocks and mixins of a compiled template.
+func (this *EvalJade) prepare(compiled *CompiledTemplate) {
+	this.templates = compiled.Templates
+	for k, v := range compiled.Blocks {
+		this.Blocks[k] = v
+	}
+	for k, v := range compiled.Mixins {
+		this.Mixins[k] = v
+	}
+	for _, template := range compiled.Chain {
+		this.files = append(this.files, template.Path)
+	}
 }
 
-func (this *EvalJade) RenderString(template string) {
-	parse := Parse(template)
-	this.buildJadeFromParseResult(&Template{"fromstring", []byte(template), parse, true})
+// RenderString parses a jade string and renders it.
+func (this *EvalJade) RenderString(template string) (err error) {
+	defer this.afterRender(this.beforeEvent("render", "fromstring"), &err)
+	defer this.flushOutput(&err)
+	defer this.recover(&err)
+	parse := parseTemplate("fromstring", []byte(template))
+	fromstring := &Template{Name: "fromstring", File: []byte(template), Root: parse, IsJade: true}
+	this.parseWarnings(fromstring)
+	this.buildJadeFromParseResult(fromstring)
 	if len(parse.Extends) > 0 {
-		this.RenderFile(parse.Extends)
+		this.currTemplate = fromstring
+		this.pushCall("extends", parse.Extends, findExtends(parse.Root))
+		this.observe("extends", parse.Extends, func() { this.evalFile(parse.Extends) })
+		this.popCall()
+		return
 	}
-	this.currTemplate = &Template{Name: "fromstring", File: []byte(template), Root: parse, IsJade: true}
-	this.Exec(parse.Root)
+	this.currTemplate, this.currPart = fromstring, nil
+	this.router(parse.Root)
 	return
 }
@@ -8,15 +8,21 @@ import (
 	"strings"
 )
 
-func (this *EvalJade) registerStandardFunctions() {
+// standardFunctions is the functions available to every template.
+var standardFunctions funcMap = funcMap{
+	"len":    length,
+	"upper":  upper,
+	"lower":  lower,
+	"format": format,
+	"isnull": isnull,
+	"ifnull": ifnull,
+	"json":   tojson,
+}
 
-	registerFunction(this.builtin, "len", length)
-	registerFunction(this.builtin, "upper", upper)
-	registerFunction(this.builtin, "lower", lower)
-	registerFunction(this.builtin, "format", format)
-	registerFunction(this.builtin, "isnull", isnull)
-	registerFunction(this.builtin, "ifnull", ifnull)
-	registerFunction(this.builtin, "json", tojson)
+func (this *EvalJade) registerStandardFunctions() {
+	for name, fn := range standardFunctions {
+		registerFunction(this.builtin, name, fn)
+	}
 }
 
 func length(value interface{}) int {
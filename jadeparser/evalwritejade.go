@@ -1,25 +1,53 @@
 package jadeparser
 
 import (
+	"bufio"
 	"io"
+	"reflect"
 	"strings"
 )
 
 type jadewriter struct {
 	wr          io.Writer
+	buf         *bufio.Writer //Output is buffered and written to wr when flushed.
 	template    *EvalJade
 	lastnewline bool
+	written     int64 //Number of bytes written, see Limits.MaxOutput.
 }
 
 func (this *jadewriter) write(txt string) (int, error) {
+	this.template.checkOutput(len(txt))
+	this.written += int64(len(txt))
 	this.lastnewline = false
 	if this.template.Beautify {
 		this.lastnewline = strings.HasSuffix(txt, "\n")
 	}
-	return this.wr.Write([]byte(txt))
+	n, err := this.buf.WriteString(txt)
+	if err != nil {
+		panic(&WriteError{err})
+	}
+	return n, nil
+}
+
+// flushWriter is implemented by writers that can send buffered data to the client, like http.Flusher.
+type flushWriter interface {
+	Flush()
+}
+
+// flush writes the buffered output to the writer, and flushes the writer when it is a http.Flusher.
+func (this *jadewriter) flush() {
+	if err := this.buf.Flush(); err != nil {
+		panic(&WriteError{err})
+	}
+	if flusher, ok := this.wr.(flushWriter); ok {
+		flusher.Flush()
+	}
 }
 
 func (this *jadewriter) writeValue(val interface{}) (int, error) {
+	if this.template.Sandbox != nil {
+		return this.write(this.template.valueText(reflect.ValueOf(val)))
+	}
 	return this.write(ObjToString(val))
 }
 
@@ -83,6 +111,9 @@ func (this *jadewriter) HtmlTag(node *TreeNode, tag *HtmlTagToken) {
 		this.write(tag.TagName)
 		this.write(">")
 		this.beautifyNewLine()
+		if InSlice(this.template.FlushTags, tag.TagName) {
+			this.flush()
+		}
 	}
 }
 
@@ -109,6 +140,16 @@ func (this *jadewriter) AttributeItem(node *TreeNode) {
 			this.write(" ")
 			this.write(val.Text + "=\"" + val.Text + "\"")
 		}
+	case *FuncToken:
+		if val.Name == attributesFunc {
+			//&attributes() with empty attributes writes nothing.
+			if text := this.template.getText(node); len(text) > 0 {
+				this.write(" " + text)
+			}
+			return
+		}
+		this.write(" ")
+		this.router(node)
 	default:
 		this.write(" ")
 		this.router(node)
@@ -223,7 +264,7 @@ func (this *jadewriter) lrfunc(node *TreeNode, token *LRFuncToken) {
 }
 
 func (this *jadewriter) stdfunc(node *TreeNode, token *FuncToken) {
-	this.write(ObjToString(this.template.evalFunc(node, token)))
+	this.write(this.template.valueText(this.template.evalFunc(node, token)))
 }
 
 func (this *jadewriter) text(token *TextToken) {
@@ -233,7 +274,7 @@ func (this *jadewriter) text(token *TextToken) {
 func (this *jadewriter) jadecase(node *TreeNode, fn *FuncToken) {
 	var caseval interface{}
 	if len(fn.Arguments) == 1 {
-		caseval = this.template.getValue(fn.Arguments[0]).Interface()
+		caseval = toCommonType(this.template.getValue(fn.Arguments[0])).Interface()
 	}
 
 	var whenprev bool
@@ -248,7 +289,7 @@ func (this *jadewriter) jadecase(node *TreeNode, fn *FuncToken) {
 				whentrue = this.template.getBool(when.Arguments[0])
 			} else {
 				var err error
-				whentrue, err = eq(caseval, this.template.getValue(when.Arguments[0]).Interface())
+				whentrue, err = eq(caseval, toCommonType(this.template.getValue(when.Arguments[0])).Interface())
 				if err != nil {
 					panic("case: Error on When value. " + err.Error())
 				}
@@ -0,0 +1,1394 @@
+package jadeparser
+
+import (
+	"bytes"
+	"fmt"
+	goformat "go/format"
+	gotoken "go/token"
+	"go/types"
+	"html"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateOptions configures the Go source generated by Generate.
+type GenerateOptions struct {
+	//Package is the package name of the generated file. Default: the name of Pkg, or views
+	Package string
+	//Func is the name of the render function. Default: Render followed by the template name, Example: RenderIndex
+	Func string
+	//Type is the type of the data parameter, Example: the type of *models.Page found with go/types.
+	//Members of data is checked against it when generating, and the types of var declarations,
+	//each items and mixin parameters is found from it. Default: interface{}
+	Type types.Type
+	//Pkg is the package the generated file is part of. Types declared in Pkg is written
+	//without a package name, other packages used by the generated code is imported.
+	Pkg *types.Package
+}
+
+// Generate returns Go source rendering a compiled template, with a render function
+//
+//	func RenderIndex(wr io.Writer, data *models.Page) (err error)
+//
+// The members used by the template is found on the data type when generating, a
+// misspelled member is a error, and the fields and methods is accessed without reflection.
+// Var declarations, each items and mixin parameters has the type of their value, a mixin
+// called with values of different types is generated once for each list of types. Values
+// without a static type, like the items of a map declared in the template, can be written
+// and compared, members of these values is a error. Mixins see their parameters and data,
+// not the variables of the caller. Functions is builtin functions or methods of data,
+// registered functions, globals and beautify is not supported. Fields read through a nil
+// pointer is zero values, where the evaluator writes fields not defined as empty text, and
+// errors is returned as a TemplateError located at the statement that failed.
+func Generate(compiled *CompiledTemplate, opts GenerateOptions) (source []byte, err error) {
+	defer errRecover(&err)
+	if len(opts.Package) == 0 && opts.Pkg != nil {
+		opts.Package = opts.Pkg.Name()
+	}
+	if len(opts.Package) == 0 {
+		opts.Package = "views"
+	}
+	if len(opts.Func) == 0 {
+		opts.Func = "Render" + exportedName(compiled.Name)
+	}
+	if opts.Type == nil {
+		opts.Type = anyType
+	}
+	gen := &generator{
+		compiled:   compiled,
+		body:       new(bytes.Buffer),
+		data:       opts.Type,
+		pkg:        opts.Pkg,
+		imports:    map[string]string{"bufio": "bufio", "io": "io", jadeparserPath: "jadeparser"},
+		blocks:     make(map[string]*jadePart),
+		mixins:     make(map[string]*jadePart),
+		mixinFuncs: make(map[*jadePart][]genMixin),
+		scopes:     []map[string]genLocal{make(map[string]genLocal)},
+	}
+	for k, v := range compiled.Blocks {
+		gen.blocks[k] = v
+	}
+	for k, v := range compiled.Mixins {
+		gen.mixins[k] = v
+	}
+	root := compiled.Root
+	gen.part = &jadePart{root.Name, nil, root.File, root.Layer}
+	dataType := gen.typeName(nil, opts.Type)
+	if ptr, ok := opts.Type.(*types.Pointer); ok && gen.nameable(ptr.Elem()) {
+		//members of nil data is zero values, the same as members of a new value.
+		gen.dataNew = "new(" + gen.typeName(nil, ptr.Elem()) + ")"
+	}
+	if root.IsJade {
+		gen.part.Part = root.Root.Root
+		gen.router(root.Root.Root)
+	} else {
+		gen.write(string(root.File))
+	}
+	gen.flushText()
+
+	out := new(bytes.Buffer)
+	fmt.Fprintf(out, "// Code generated by gojade generate from %s. DO NOT EDIT.\n\n", compiled.Name)
+	fmt.Fprintf(out, "package %s\n\nimport (\n", opts.Package)
+	gen.writeImports(out)
+	fmt.Fprintf(out, ")\n\n// %s renders %s.\n", opts.Func, compiled.Name)
+	fmt.Fprintf(out, "func %s(wr io.Writer, data %s) (err error) {\n", opts.Func, dataType)
+	out.WriteString("var at jadeparser.Location\ndefer jadeparser.RecoverError(&err, &at)\nw := bufio.NewWriter(wr)\n")
+	if len(gen.dataNew) > 0 {
+		fmt.Fprintf(out, "if data == nil {\ndata = %s\n}\n", gen.dataNew)
+	}
+	for _, decl := range gen.mixinDecls {
+		out.WriteString(decl)
+	}
+	for _, def := range gen.mixinDefs {
+		out.Write(def.Bytes())
+	}
+	out.Write(gen.body.Bytes())
+	out.WriteString("if err := w.Flush(); err != nil {\nreturn &jadeparser.WriteError{Err: err}\n}\nreturn nil\n}\n")
+	source, err = goformat.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Generated source for %q is invalid. %v", compiled.Name, err)
+	}
+	return source, nil
+}
+
+// exportedName returns a template name as a exported Go name. Example: user/edit-profile.jade returns UserEditProfile
+func exportedName(name string) string {
+	name = strings.TrimSuffix(name, path.Ext(name))
+	buf := new(bytes.Buffer)
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// genLocal is a variable declared in the template.
+type genLocal struct {
+	name string
+	typ  types.Type
+}
+
+// genMixin is the func generated for a mixin called with arguments of the types in params.
+type genMixin struct {
+	name   string
+	params []types.Type
+}
+
+type generator struct {
+	compiled   *CompiledTemplate
+	body       *bytes.Buffer //Code of the function currently generated.
+	text       bytes.Buffer  //Static text not written to the body yet.
+	part       *jadePart     //The template or mixin currently generated, used to locate errors.
+	data       types.Type
+	dataNew    string //Expression for a new data value used when data is nil, empty when data is not a pointer.
+	pkg        *types.Package
+	imports    map[string]string //Import path to package name.
+	doctype    string
+	blocks     map[string]*jadePart
+	mixins     map[string]*jadePart
+	mixinFuncs map[*jadePart][]genMixin
+	mixinDecls []string
+	mixinDefs  []*bytes.Buffer
+	scopes     []map[string]genLocal
+	files      []string //Blocks and files currently generated, used to detect cycles.
+	temps      int
+}
+
+func (this *generator) errorf(node *TreeNode, format string, args ...interface{}) {
+	pos := 0
+	if node != nil {
+		pos = node.Pos
+	}
+	panic(newTemplateError(this.part.Name, this.part.File, pos, fmt.Sprintf(format, args...)))
+}
+
+// locate returns a call setting the location of the statement generated for node, the
+// location of errors returned by the render function, see Location.
+func (this *generator) locate(node *TreeNode) string {
+	terr := new(TemplateError)
+	terr.setPosition(this.part.File, node.Pos)
+	return fmt.Sprintf("at.Set(%q, %v, %v)", this.part.Name, terr.Line, terr.Column)
+}
+
+// write adds static text to the output.
+func (this *generator) write(text string) {
+	this.text.WriteString(text)
+}
+
+// code adds a line of code, after the static text written before it.
+func (this *generator) code(format string, args ...interface{}) {
+	this.flushText()
+	fmt.Fprintf(this.body, format+"\n", args...)
+}
+
+func (this *generator) flushText() {
+	if this.text.Len() > 0 {
+		fmt.Fprintf(this.body, "w.WriteString(%s)\n", strconv.Quote(this.text.String()))
+		this.text.Reset()
+	}
+}
+
+func (this *generator) temp(prefix string) string {
+	this.temps++
+	return fmt.Sprintf("%s%v", prefix, this.temps)
+}
+
+func (this *generator) pushScope() {
+	this.scopes = append(this.scopes, make(map[string]genLocal))
+}
+
+func (this *generator) popScope() {
+	this.scopes = this.scopes[:len(this.scopes)-1]
+}
+
+// declare adds a variable to the inner most scope and returns its Go name. A variable
+// declared again in the same scope is given a new Go name, as the type may differ.
+func (this *generator) declare(name string, typ types.Type) string {
+	scope := this.scopes[len(this.scopes)-1]
+	goname := localName(name)
+	if _, ok := scope[name]; ok {
+		goname = this.temp(goname)
+	}
+	scope[name] = genLocal{goname, typ}
+	return goname
+}
+
+func (this *generator) lookup(name string) (genLocal, bool) {
+	for i := len(this.scopes) - 1; i >= 0; i-- {
+		if local, ok := this.scopes[i][name]; ok {
+			return local, true
+		}
+	}
+	return genLocal{}, false
+}
+
+// localName returns the Go name of a template variable, the suffix keeps the name
+// from clashing with Go keywords and the names used by the render function.
+func localName(name string) string {
+	buf := new(bytes.Buffer)
+	for _, r := range name {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			buf.WriteRune(r)
+		} else {
+			buf.WriteRune('_')
+		}
+	}
+	return buf.String() + "_"
+}
+
+// enter records a block or file generated, and fails when it is already generated.
+func (this *generator) enter(node *TreeNode, kind string, name string) {
+	key := kind + ":" + name
+	if InSlice(this.files, key) {
+		this.errorf(node, "%s %q includes itself.", kind, name)
+	}
+	this.files = append(this.files, key)
+}
+
+func (this *generator) exit() {
+	this.files = this.files[:len(this.files)-1]
+}
+
+func (this *generator) router(node *TreeNode) {
+	switch val := node.Value.(type) {
+	case *EmptyToken:
+		this.content(node)
+	case *HtmlDocTypeToken:
+		this.docType(node, val)
+	case *HtmlTagToken:
+		this.htmlTag(node, val)
+	case *NumberToken:
+		this.write(strconv.FormatFloat(val.Value, 0, 0, 64))
+	case *TextToken:
+		this.write(val.Text)
+	case *GroupToken:
+		this.group(node, val)
+	case *KeyValueToken:
+		this.write(val.Key + "=\"")
+		this.router(val.Value)
+		this.write("\"")
+	case *LRFuncToken:
+		this.errorf(node, "Function %q not supported.", val.Name)
+	case *FuncToken:
+		this.function(node, val)
+	case *CommentToken:
+		if val.CommentType == "//" {
+			this.write("<!--")
+			for _, item := range node.items {
+				this.router(item)
+			}
+			this.write("-->")
+		}
+	}
+}
+
+// content generates the items of a node, with if, unless and else statements as a Go if chain.
+func (this *generator) content(node *TreeNode) {
+	chain := false
+	for _, item := range node.Items() {
+		fn, ok := item.Value.(*FuncToken)
+		if ok && (fn.Name == "if" || fn.Name == "unless") {
+			if chain {
+				this.code("}")
+			}
+			this.code("%s", this.locate(item))
+			this.code("if %s {", this.condition(item, fn))
+			this.scoped(item)
+			chain = true
+			continue
+		}
+		if ok && fn.Name == "else" {
+			if !chain {
+				continue
+			}
+			if len(fn.Arguments) > 0 {
+				this.code("} else if %s && %s {", this.locate(item), this.condition(item, fn))
+				this.scoped(item)
+				continue
+			}
+			this.code("} else {")
+			this.scoped(item)
+			this.code("}")
+			chain = false
+			continue
+		}
+		if chain {
+			this.code("}")
+			chain = false
+		}
+		this.router(item)
+	}
+	if chain {
+		this.code("}")
+	}
+}
+
+// scoped generates the content of a node in a new scope.
+func (this *generator) scoped(node *TreeNode) {
+	this.pushScope()
+	this.content(node)
+	this.popScope()
+}
+
+func (this *generator) condition(node *TreeNode, fn *FuncToken) string {
+	if len(fn.Arguments) != 1 {
+		this.errorf(node, "Invalid number of arguments statement %q, expecting 1 found %v", fn.Name, len(fn.Arguments))
+	}
+	condition := this.truth(this.value(fn.Arguments[0]))
+	if fn.Name == "unless" {
+		return "!" + condition
+	}
+	return condition
+}
+
+func (this *generator) docType(node *TreeNode, doctype *HtmlDocTypeToken) {
+	arg := strings.Trim(doctype.Attributes[0], " ")
+	switch arg {
+	case "html":
+		this.write("<!DOCTYPE html>")
+	case "xml":
+		this.write(`<?xml version="1.0" encoding="utf-8" ?>`)
+	case "transitional":
+		this.write(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">`)
+	case "strict":
+		this.write(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`)
+	case "frameset":
+		this.write(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Frameset//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd">`)
+	case "1.1":
+		this.write(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`)
+	case "basic":
+		this.write(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML Basic 1.1//EN" "http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd">`)
+	case "mobile":
+		this.write(`<!DOCTYPE html PUBLIC "-//WAPFORUM//DTD XHTML Mobile 1.2//EN" "http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd">`)
+	default:
+		this.errorf(node, "Invalid doctype '%s'", arg)
+	}
+	this.doctype = arg
+}
+
+func (this *generator) htmlTag(node *TreeNode, tag *HtmlTagToken) {
+	this.write("<" + tag.TagName)
+	for _, attr := range tag.Attributes {
+		this.attribute(attr)
+	}
+	if tag.SelfClosing {
+		if this.doctype == "html" {
+			this.write(">")
+		} else {
+			this.write("/>")
+		}
+		return
+	}
+	this.write(">")
+	this.content(node)
+	this.write("</" + tag.TagName + ">")
+}
+
+func (this *generator) attribute(node *TreeNode) {
+	switch val := node.Value.(type) {
+	case *KeyValueToken:
+		this.keyValueAttribute(node, val)
+	case *TextToken:
+		if this.doctype == "html" {
+			this.write(" " + val.Text)
+		} else {
+			this.write(" " + val.Text + "=\"" + val.Text + "\"")
+		}
+	case *FuncToken:
+		if val.Name == attributesFunc {
+			//&attributes() with empty attributes writes nothing.
+			text := this.temp("attr")
+			this.code("%s", this.locate(node))
+			this.code("if %s := %s; len(%s) > 0 {", text, this.textExpr(this.value(node)), text)
+			this.code("w.WriteString(\" \" + %s)", text)
+			this.code("}")
+			return
+		}
+		this.write(" ")
+		this.router(node)
+	default:
+		this.write(" ")
+		this.router(node)
+	}
+}
+
+func (this *generator) keyValueAttribute(node *TreeNode, keyvalue *KeyValueToken) {
+	valueNode, escape := stripEscapeHtml(keyvalue.Value)
+	switch strings.ToLower(keyvalue.Key) {
+	case "style":
+		this.write(" " + keyvalue.Key + "=\"")
+		del := ""
+		for _, item := range valueNode.items {
+			kv, ok := item.Value.(*KeyValueToken)
+			if !ok {
+				this.errorf(item, "Expecting Key Value Pair in style attribute.")
+			}
+			this.write(del + kv.Key + ":")
+			this.router(kv.Value)
+			del = ";"
+		}
+		this.write("\"")
+		return
+	case "class":
+		if _, ok := valueNode.Value.(*GroupToken); ok {
+			this.write(" " + keyvalue.Key + "=\"")
+			this.router(valueNode)
+			this.write("\"")
+			return
+		}
+	}
+	switch val := valueNode.Value.(type) {
+	case *BoolToken:
+		this.write(AttributeText(keyvalue.Key, val.Value, escape, this.doctype))
+	case *TextToken:
+		this.write(AttributeText(keyvalue.Key, val.Text, escape, this.doctype))
+	case *NumberToken:
+		this.write(AttributeText(keyvalue.Key, val.Value, escape, this.doctype))
+	default:
+		expr, _ := this.value(valueNode)
+		this.code("%s", this.locate(valueNode))
+		this.code("w.WriteString(jadeparser.AttributeText(%q, %s, %v, %q))", keyvalue.Key, expr, escape, this.doctype)
+	}
+}
+
+func (this *generator) group(node *TreeNode, group *GroupToken) {
+	start, end, del := "", "", " "
+	switch group.GroupType {
+	case "()":
+		start, end = "(", ")"
+	case "{}":
+		start, end, del = "{", "}", ","
+	}
+	this.write(start)
+	for i, item := range node.items {
+		if i > 0 {
+			this.write(del)
+		}
+		this.router(item)
+	}
+	this.write(end)
+}
+
+// function generates a keyword statement, or writes the value of a function or variable.
+func (this *generator) function(node *TreeNode, token *FuncToken) {
+	if token.IsIdentity {
+		this.writeValue(node, false)
+		return
+	}
+	switch token.Name {
+	case "mixin", "extends":
+	case "if", "unless", "else", "when", "default":
+		this.errorf(node, "Unexpected %q statement.", token.Name)
+	case "case":
+		this.jadeCase(node, token)
+	case "var":
+		this.jadeVar(node, token)
+	case "each":
+		this.jadeEach(node, token)
+	case escapeHtmlFunc:
+		this.writeValue(token.Arguments[0], true)
+	case jadeMixinFunc:
+		this.jadeMixin(node, token)
+	case jadeBlockFunc:
+		this.jadeBlock(node, token)
+	case "include":
+		this.jadeInclude(node, token)
+	case "flush":
+		this.code("jadeparser.FlushWriter(w, wr)")
+	default:
+		this.writeValue(node, false)
+	}
+}
+
+// writeValue writes the value of a expression, constants is written as static text.
+func (this *generator) writeValue(node *TreeNode, escape bool) {
+	var text string
+	switch val := node.Value.(type) {
+	case *TextToken:
+		text = val.Text
+	case *NumberToken:
+		text = ObjToString(val.Value)
+	case *BoolToken:
+		text = ObjToString(val.Value)
+	default:
+		expr, typ := this.expr(node)
+		this.code("%s", this.locate(node))
+		switch {
+		case typ == voidType:
+			//methods without results is called for unbuffered code, see goodFunc.
+			this.code("%s", expr)
+		case escape:
+			this.code("w.WriteString(%s)", this.escapeExpr(expr, typ))
+		default:
+			this.code("w.WriteString(%s)", this.textExpr(expr, typ))
+		}
+		return
+	}
+	if escape {
+		text = html.EscapeString(text)
+	}
+	this.write(text)
+}
+
+func (this *generator) jadeVar(node *TreeNode, token *FuncToken) {
+	if len(token.Arguments) != 2 {
+		this.errorf(node, "var, expects 2 arguments, a variable name and a value. Ex: city='New York'")
+	}
+	name, ok := token.Arguments[0].Value.(*FuncToken)
+	if !ok || !name.IsIdentity {
+		this.errorf(node, "var declaration expecting variable name. Found %s", token.Arguments[0].Value.String())
+	}
+	expr, typ := defaultValue(this.value(token.Arguments[1]))
+	this.code("%s", this.locate(node))
+	if local, ok := this.lookup(name.Name); ok && types.AssignableTo(typ, local.typ) {
+		this.code("%s = %s", local.name, expr)
+		return
+	}
+	local := this.declare(name.Name, typ)
+	this.code("%s := %s", local, expr)
+	this.code("_ = %s", local)
+}
+
+// jadeEach generates a each statement as a Go range loop over arrays, slices and maps,
+// and a for loop for numbers. Values without a static type is iterated at runtime.
+func (this *generator) jadeEach(node *TreeNode, fn *FuncToken) {
+	if len(fn.Arguments) != 3 {
+		this.errorf(node, "each statement invalid number of arguments, expecting at least 2. found %v", len(fn.Arguments))
+	}
+	item, ok := fn.Arguments[0].Value.(*FuncToken)
+	if !ok || !item.IsIdentity {
+		this.errorf(node, "First argument of 'each' keyword must be a variable name.")
+	}
+	index := ""
+	if _, ok := fn.Arguments[1].Value.(*EmptyToken); !ok {
+		if varvalue, ok := fn.Arguments[1].Value.(*FuncToken); ok && varvalue.IsIdentity {
+			index = varvalue.Name
+		} else {
+			this.errorf(node, "Second argument of 'each' keyword must be a variable name or left blank.")
+		}
+	}
+	this.pushScope()
+	defer this.popScope()
+	if count, ok := fn.Arguments[2].Value.(*NumberToken); ok {
+		name := this.declare(item.Name, types.Typ[types.Int])
+		this.code("for %s := 0; %s < %v; %s++ {", name, name, int(count.Value), name)
+		this.code("_ = %s", name)
+		this.scoped(node)
+		this.code("}")
+		return
+	}
+	expr, typ := this.value(fn.Arguments[2])
+	this.code("%s", this.locate(node))
+	if ptr, ok := typ.Underlying().(*types.Pointer); ok && !isLinearMap(typ) {
+		switch ptr.Elem().Underlying().(type) {
+		case *types.Slice, *types.Map:
+			expr, typ = "*"+expr, ptr.Elem()
+		case *types.Array:
+			typ = ptr.Elem()
+		}
+	}
+	var key, elem types.Type
+	switch under := typ.Underlying().(type) {
+	case *types.Slice:
+		key, elem = types.Typ[types.Int], under.Elem()
+	case *types.Array:
+		key, elem = types.Typ[types.Int], under.Elem()
+	case *types.Map:
+		key, elem = under.Key(), under.Elem()
+	}
+	indexName := "_"
+	switch {
+	case elem != nil:
+		if len(index) > 0 {
+			indexName = this.declare(index, key)
+		}
+		name := this.declare(item.Name, elem)
+		this.code("for %s, %s := range %s {", indexName, name, expr)
+		this.code("_ = %s", name)
+	case isNumberType(typ):
+		name := this.declare(item.Name, types.Typ[types.Int])
+		this.code("for %s := 0; %s < int(%s); %s++ {", name, name, expr, name)
+		this.code("_ = %s", name)
+	case isAny(typ) || isLinearMap(typ):
+		iter := this.temp("iter")
+		this.code("for _, %s := range jadeparser.Iterate(%s) {", iter, expr)
+		if len(index) > 0 {
+			indexName = this.declare(index, anyType)
+		}
+		name := this.declare(item.Name, anyType)
+		this.code("%s, %s := %s.Key, %s.Value", indexName, name, iter, iter)
+		this.code("_ = %s", name)
+	default:
+		this.errorf(node, "Invalid value type after 'in' keyword, expecting an array, map or number found %s", this.typeString(typ))
+	}
+	if indexName != "_" {
+		this.code("_ = %s", indexName)
+	}
+	this.scoped(node)
+	this.code("}")
+}
+
+// jadeCase generates a case statement as a Go switch. A when without content falls
+// through to the next when, and default must be the last statement.
+func (this *generator) jadeCase(node *TreeNode, fn *FuncToken) {
+	value, valueType := "", types.Type(nil)
+	if len(fn.Arguments) == 1 {
+		var expr string
+		expr, valueType = defaultValue(this.value(fn.Arguments[0]))
+		value = this.temp("case")
+		this.code("%s", this.locate(node))
+		this.code("%s := %s", value, expr)
+		this.code("_ = %s", value)
+	}
+	this.code("switch {")
+	conditions := make([]string, 0)
+	for i, whenNode := range node.items {
+		when, ok := whenNode.Value.(*FuncToken)
+		if !ok || (when.Name != "when" && when.Name != "default") {
+			this.errorf(whenNode, "Expecting a case or default statement.")
+		}
+		if when.Name == "default" {
+			if i < len(node.items)-1 {
+				this.errorf(whenNode, "default must be the last statement of a case.")
+			}
+			this.code("default:")
+			this.scoped(whenNode)
+			continue
+		}
+		if len(when.Arguments) == 0 {
+			this.errorf(whenNode, "when statement expects a value.")
+		}
+		expr, typ := this.value(when.Arguments[0])
+		if len(value) > 0 {
+			conditions = append(conditions, this.locate(whenNode)+" && "+this.compare("==", value, valueType, expr, typ))
+		} else {
+			conditions = append(conditions, this.locate(whenNode)+" && "+this.truth(expr, typ))
+		}
+		if len(whenNode.items) > 0 {
+			this.code("case %s:", strings.Join(conditions, ", "))
+			this.scoped(whenNode)
+			conditions = conditions[:0]
+		}
+	}
+	this.code("}")
+}
+
+func (this *generator) jadeInclude(node *TreeNode, fn *FuncToken) {
+	if len(fn.Arguments) == 0 {
+		return
+	}
+	text, ok := fn.Arguments[0].Value.(*TextToken)
+	if !ok {
+		this.errorf(node, "include expects a file name.")
+	}
+	template, ok := this.compiled.Templates[templateKey(text.Text, this.part.Layer)]
+	if !ok {
+		this.errorf(node, "Template %q not loaded by Compile.", text.Text)
+	}
+	if !template.IsJade {
+		this.write(string(template.File))
+		return
+	}
+	if len(template.Root.Extends) > 0 {
+		this.errorf(node, "Included template %q extends %q, generating included templates extending a layout is not supported.", text.Text, template.Root.Extends)
+	}
+	this.enter(node, "include", template.Name)
+	defer this.exit()
+	for k, v := range template.Root.Mixins {
+		if _, ok := this.mixins[k]; !ok {
+			this.mixins[k] = &jadePart{template.Name, v, template.File, template.Layer}
+		}
+	}
+	for k, v := range template.Root.Blocks {
+		if _, ok := this.blocks[k]; !ok {
+			this.blocks[k] = &jadePart{template.Name, v, template.File, template.Layer}
+		}
+	}
+	prevpart := this.part
+	this.part = &jadePart{template.Name, template.Root.Root, template.File, template.Layer}
+	this.router(template.Root.Root)
+	this.part = prevpart
+}
+
+func (this *generator) jadeBlock(node *TreeNode, blockfn *FuncToken) {
+	blockname := ""
+	if len(blockfn.Arguments) > 0 {
+		if bname, ok := blockfn.Arguments[0].Value.(*FuncToken); ok {
+			blockname = bname.Name
+		}
+	}
+	if len(blockname) == 0 {
+		//mixin block has no name and is passed to the mixin.
+		local, ok := this.lookup("block")
+		if !ok {
+			this.errorf(node, "Block %q not found.", blockname)
+		}
+		this.code("if %s != nil {", local.name)
+		this.code("%s()", local.name)
+		this.code("}")
+		return
+	}
+	block, ok := this.blocks[blockname]
+	if !ok {
+		this.errorf(node, "Block %q not found.", blockname)
+	}
+	this.enter(node, "block", blockname)
+	defer this.exit()
+	prevpart := this.part
+	this.part = block
+	this.content(block.Part)
+	this.part = prevpart
+}
+
+func (this *generator) jadeMixin(node *TreeNode, token *FuncToken) {
+	fn, ok := token.Arguments[0].Value.(*FuncToken)
+	if !ok {
+		this.errorf(node, "Expecting mixin function call.")
+	}
+	mixindef, ok := this.mixins[fn.Name]
+	if !ok {
+		this.errorf(node, "Mixin %q not found.", fn.Name)
+	}
+	//arguments and attributes is only passed when the mixin is declared with parameters.
+	args := make([]string, 0)
+	argTypes := make([]types.Type, 0)
+	attributes := "nil"
+	if params, ok := mixinParams(mixindef); ok {
+		if len(fn.Arguments) < len(params) {
+			this.errorf(node, "Mixin %q expects %v arguments, found %v.", fn.Name, len(params), len(fn.Arguments))
+		}
+		for i := range params {
+			expr, typ := defaultValue(this.value(fn.Arguments[i]))
+			args = append(args, expr)
+			argTypes = append(argTypes, typ)
+		}
+		keyvalues := make([]string, 0)
+		if fn.Next != nil && fn.Next.Name == "attributes" {
+			for _, v := range fn.Next.Arguments {
+				op, ok := v.Value.(*OperatorToken)
+				if !ok || op.Operator != "=" {
+					this.errorf(node, "Expecting Key Value pairs seperated by '=' found '%s'", v.String())
+				}
+				key, ok := v.Items()[0].Value.(*FuncToken)
+				if !ok {
+					this.errorf(node, "Expecting a attribute name found '%s'", v.Items()[0].String())
+				}
+				expr, _ := this.value(v.Items()[1])
+				keyvalues = append(keyvalues, strconv.Quote(key.Name), expr)
+			}
+		}
+		attributes = "jadeparser.NewAttributes(" + strings.Join(keyvalues, ", ") + ")"
+	}
+	name := this.mixinFunc(node, mixindef, argTypes)
+	args = append(args, attributes)
+	this.code("%s", this.locate(node))
+	if len(node.Items()) == 0 {
+		this.code("%s(%s, nil)", name, strings.Join(args, ", "))
+		return
+	}
+	this.code("%s(%s, func() {", name, strings.Join(args, ", "))
+	this.scoped(node)
+	this.code("})")
+}
+
+// mixinFunc returns the name of the func variable rendering a mixin with parameters of
+// the argument types, and generates the func the first time the mixin is called with the types.
+func (this *generator) mixinFunc(node *TreeNode, mixindef *jadePart, argTypes []types.Type) string {
+	for _, instance := range this.mixinFuncs[mixindef] {
+		if identicalTypes(instance.params, argTypes) {
+			return instance.name
+		}
+	}
+	name := this.temp("mixin")
+	this.mixinFuncs[mixindef] = append(this.mixinFuncs[mixindef], genMixin{name, argTypes})
+	params, ok := mixinParams(mixindef)
+	typeNames := make([]string, len(argTypes))
+	for i, typ := range argTypes {
+		typeNames[i] = this.typeName(node, typ)
+	}
+	prevbody, prevpart, prevscopes := this.body, this.part, this.scopes
+	prevtext := this.text.String()
+	this.text.Reset()
+	this.body, this.part = new(bytes.Buffer), mixindef
+	this.scopes = []map[string]genLocal{make(map[string]genLocal)}
+	signature := make([]string, 0)
+	for i, param := range params {
+		signature = append(signature, this.declare(param, argTypes[i])+" "+typeNames[i])
+	}
+	attributes := localName("attributes")
+	if ok {
+		attributes = this.declare("attributes", linearMapType)
+	}
+	signature = append(signature, attributes+" *jadeparser.LinearMap", this.declare("block", blockType)+" func()")
+	fnType := "func(" + strings.Join(signature, ", ") + ")"
+	this.mixinDecls = append(this.mixinDecls, fmt.Sprintf("var %s %s\n", name, fnType))
+	this.code("%s = %s {", name, fnType)
+	if ok {
+		this.code("if %s == nil {", attributes)
+		this.code("%s = jadeparser.NewAttributes()", attributes)
+		this.code("}")
+	}
+	this.content(mixindef.Part)
+	this.code("}")
+	this.mixinDefs = append(this.mixinDefs, this.body)
+	this.body, this.part, this.scopes = prevbody, prevpart, prevscopes
+	this.text.WriteString(prevtext)
+	return name
+}
+
+func identicalTypes(list1 []types.Type, list2 []types.Type) bool {
+	if len(list1) != len(list2) {
+		return false
+	}
+	for i := range list1 {
+		if !types.Identical(list1[i], list2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// value returns a Go expression for the value of a node, and fails when the node is a
+// call to a method without results.
+func (this *generator) value(node *TreeNode) (string, types.Type) {
+	expr, typ := this.expr(node)
+	if typ == voidType {
+		this.errorf(node, "%s has no result, and can't be used as a value.", node.String())
+	}
+	return expr, typ
+}
+
+// expr returns a Go expression for the value of a node, and the type of the expression.
+// Constants has untyped types, the same as Go constants.
+func (this *generator) expr(node *TreeNode) (string, types.Type) {
+	switch val := node.Value.(type) {
+	case *NumberToken:
+		return strconv.FormatFloat(val.Value, 'g', -1, 64), types.Typ[types.UntypedFloat]
+	case *TextToken:
+		return strconv.Quote(val.Text), types.Typ[types.UntypedString]
+	case *BoolToken:
+		return strconv.FormatBool(val.Value), types.Typ[types.UntypedBool]
+	case *GroupToken:
+		return this.groupExpr(node, val)
+	case *OperatorToken:
+		if val.Operator == "?" {
+			return this.conditional(node)
+		}
+		if val.Operator == "=" || val.Operator == ":" {
+			this.errorf(node, "Unexpected operator %q.", val.Operator)
+		}
+		return this.operator(node, val)
+	case *FuncToken:
+		if val.IsIdentity {
+			return this.identity(node, val)
+		}
+		switch val.Name {
+		case escapeHtmlFunc:
+			return this.escapeExpr(this.value(val.Arguments[0])), types.Typ[types.String]
+		case jadeMixinFunc, jadeBlockFunc, "include", "extends", "each", "case", "var", "mixin":
+			this.errorf(node, "Unexpected %q statement in expression.", val.Name)
+		}
+		//data methods is exported and found before the builtin functions, see findFunction.
+		fn, builtin := builtinFunction(val.Name)
+		if builtin && !gotoken.IsExported(val.Name) {
+			return this.builtinCall(node, val, fn)
+		}
+		if expr, typ, ok := this.call(node, "data", this.data, val); ok {
+			return expr, typ
+		}
+		if builtin {
+			return this.builtinCall(node, val, fn)
+		}
+		this.errorf(node, "Function %q not found on %s, registered functions is not supported in generated templates.", val.Name, this.typeString(this.data))
+	}
+	this.errorf(node, "Invalid Type, Cannot get value of token type %T.", node.Value)
+	return "", nil
+}
+
+// values returns the expressions and types of a list of nodes.
+func (this *generator) values(nodes []*TreeNode) ([]string, []types.Type) {
+	exprs := make([]string, len(nodes))
+	typs := make([]types.Type, len(nodes))
+	for i, node := range nodes {
+		exprs[i], typs[i] = this.value(node)
+	}
+	return exprs, typs
+}
+
+// builtinCall returns a expression calling a builtin function. len and not is written
+// as Go when the argument has a static type.
+func (this *generator) builtinCall(node *TreeNode, fn *FuncToken, builtin interface{}) (string, types.Type) {
+	exprs, typs := this.values(fn.Arguments)
+	if len(exprs) == 1 {
+		switch typs[0].Underlying().(type) {
+		case *types.Slice, *types.Array, *types.Map:
+			if fn.Name == "len" {
+				return "len(" + exprs[0] + ")", types.Typ[types.Int]
+			}
+		}
+		if fn.Name == "len" && isStringType(typs[0]) {
+			return "len(" + exprs[0] + ")", types.Typ[types.Int]
+		}
+		if fn.Name == "not" && isBoolType(typs[0]) {
+			return "(!" + exprs[0] + ")", types.Typ[types.Bool]
+		}
+	}
+	return this.callBuiltin(fn.Name, builtin, exprs)
+}
+
+// callBuiltin returns a expression calling a builtin function at runtime, results of a
+// basic type is asserted to the type.
+func (this *generator) callBuiltin(name string, builtin interface{}, exprs []string) (string, types.Type) {
+	expr := "jadeparser.CallBuiltin(" + strconv.Quote(name)
+	for _, arg := range exprs {
+		expr += ", " + arg
+	}
+	expr += ")"
+	typ := resultType(builtin)
+	if isAny(typ) {
+		return expr, anyType
+	}
+	return expr + ".(" + typ.String() + ")", typ
+}
+
+// operator returns a expression for a operator. Operators on numbers, strings and bools is
+// written as Go, numbers is calculated and compared as float64 the same as the builtin
+// functions. Operators on other values call the builtin function.
+func (this *generator) operator(node *TreeNode, token *OperatorToken) (string, types.Type) {
+	fn, ok := builtin[token.Operator]
+	if !ok {
+		this.errorf(node, "Operator %q not supported.", token.Operator)
+	}
+	exprs, typs := this.values(node.items)
+	all := func(test func(types.Type) bool) bool {
+		for _, typ := range typs {
+			if !test(typ) {
+				return false
+			}
+		}
+		return len(typs) > 0
+	}
+	op := token.Operator
+	switch op {
+	case "and":
+		op = "&&"
+	case "or":
+		op = "||"
+	}
+	switch op {
+	case "&&", "||":
+		if all(isBoolType) {
+			return "(" + strings.Join(exprs, " "+op+" ") + ")", types.Typ[types.Bool]
+		}
+	case "+", "-", "*", "/":
+		if all(isNumberType) {
+			for i := range exprs {
+				exprs[i] = this.floatExpr(exprs[i], typs[i])
+			}
+			return "(" + strings.Join(exprs, " "+op+" ") + ")", types.Typ[types.Float64]
+		}
+		if op == "+" && all(isStringType) {
+			for i := range exprs {
+				exprs[i] = this.textExpr(exprs[i], typs[i])
+			}
+			return "(" + strings.Join(exprs, " + ") + ")", types.Typ[types.String]
+		}
+		if op == "+" {
+			//a string added to other values is concatenated, see addNumOrString.
+			for _, typ := range typs {
+				if types.Identical(types.Default(typ), types.Typ[types.String]) {
+					expr, _ := this.callBuiltin(op, fn, exprs)
+					return expr + ".(string)", types.Typ[types.String]
+				}
+			}
+		}
+	case "==", "!=", "<", ">", "<=", ">=":
+		if len(exprs) == 2 {
+			return this.compare(op, exprs[0], typs[0], exprs[1], typs[1]), types.Typ[types.Bool]
+		}
+	}
+	return this.callBuiltin(token.Operator, fn, exprs)
+}
+
+// compare returns a bool expression comparing two values. Numbers is compared as float64
+// and strings is compared as Go strings, other values is compared at runtime.
+func (this *generator) compare(op string, expr1 string, typ1 types.Type, expr2 string, typ2 types.Type) string {
+	switch {
+	case isNumberType(typ1) && isNumberType(typ2):
+		return "(" + this.floatExpr(expr1, typ1) + " " + op + " " + this.floatExpr(expr2, typ2) + ")"
+	case isStringType(typ1) && isStringType(typ2):
+		return "(" + this.textExpr(expr1, typ1) + " " + op + " " + this.textExpr(expr2, typ2) + ")"
+	case op == "==":
+		return "jadeparser.Equal(" + expr1 + ", " + expr2 + ")"
+	}
+	expr, _ := this.callBuiltin(op, builtin[op], []string{expr1, expr2})
+	return expr
+}
+
+// truth returns a bool expression for the truth of a value, see isTrue.
+func (this *generator) truth(expr string, typ types.Type) string {
+	switch typ.Underlying().(type) {
+	case *types.Slice, *types.Array, *types.Map:
+		return "(len(" + expr + ") > 0)"
+	case *types.Pointer, *types.Signature, *types.Chan:
+		return "(" + expr + " != nil)"
+	}
+	switch {
+	case isBoolType(typ):
+		return expr
+	case isStringType(typ):
+		return "(len(" + expr + ") > 0)"
+	case isNumberType(typ):
+		return "(" + expr + " != 0)"
+	}
+	return "jadeparser.IsTrue(" + expr + ")"
+}
+
+// floatExpr returns a expression for a number as float64, constants is untyped.
+func (this *generator) floatExpr(expr string, typ types.Type) string {
+	if types.Identical(typ, types.Typ[types.Float64]) || isBasic(typ, types.IsUntyped) {
+		return expr
+	}
+	return "float64(" + expr + ")"
+}
+
+// textExpr returns a expression for the text written for a value.
+func (this *generator) textExpr(expr string, typ types.Type) string {
+	switch {
+	case types.Identical(types.Default(typ), types.Typ[types.String]):
+		return expr
+	case isStringType(typ):
+		return "string(" + expr + ")"
+	}
+	return "jadeparser.ToText(" + expr + ")"
+}
+
+// escapeExpr returns a expression for the text written for a value with html escaped.
+func (this *generator) escapeExpr(expr string, typ types.Type) string {
+	if isStringType(typ) {
+		this.imports["html"] = "html"
+		return "html.EscapeString(" + this.textExpr(expr, typ) + ")"
+	}
+	return "jadeparser.EscapeText(" + expr + ")"
+}
+
+func (this *generator) groupExpr(node *TreeNode, group *GroupToken) (string, types.Type) {
+	switch group.GroupType {
+	case "{}":
+		keyvalues := make([]string, 0)
+		for _, item := range node.items {
+			kv, ok := item.Value.(*KeyValueToken)
+			if !ok {
+				this.errorf(node, "Invalid Map item. All items in a map must be of type KeyValueTokens. found %s", item.String())
+			}
+			expr, _ := this.value(kv.Value)
+			keyvalues = append(keyvalues, strconv.Quote(kv.Key), expr)
+		}
+		return "jadeparser.NewAttributes(" + strings.Join(keyvalues, ", ") + ")", linearMapType
+	case "()":
+		if len(node.items) != 1 {
+			this.errorf(node, "Math Function Should have 1 operator in the tree")
+		}
+		expr, typ := this.value(node.items[0])
+		return "(" + expr + ")", typ
+	}
+	exprs, _ := this.values(node.items)
+	return "[]interface{}{" + strings.Join(exprs, ", ") + "}", types.NewSlice(anyType)
+}
+
+// conditional returns a expression for condition?trueValue:falseValue, the expression has
+// the type of the values when both values has the same type.
+func (this *generator) conditional(node *TreeNode) (string, types.Type) {
+	if len(node.items) != 2 {
+		this.errorf(node, "? condition requires at least 2 arguments. condition?trueValue:falseValue Ex: true?'true value'. found: %s", node.String())
+	}
+	truevalue, falseexpr, falsetype := node.items[1], `""`, types.Type(types.Typ[types.String])
+	if split, ok := node.items[1].Value.(*OperatorToken); ok && split.Operator == ":" {
+		truevalue = node.items[1].items[0]
+		falseexpr, falsetype = defaultValue(this.value(node.items[1].items[1]))
+	}
+	condition := this.truth(this.value(node.items[0]))
+	trueexpr, truetype := defaultValue(this.value(truevalue))
+	typ := anyType
+	if types.Identical(truetype, falsetype) && this.nameable(truetype) {
+		typ = truetype
+	}
+	return fmt.Sprintf("func() %s {\nif %s {\nreturn %s\n}\nreturn %s\n}()", this.typeName(node, typ), condition, trueexpr, falseexpr), typ
+}
+
+// identity returns a expression for a variable. Variables not declared in the template is
+// members of data. Members is found on the type of the value, a member not found is a error.
+func (this *generator) identity(node *TreeNode, fn *FuncToken) (expr string, typ types.Type) {
+	if local, ok := this.lookup(fn.Name); ok {
+		expr, typ = local.name, local.typ
+	} else if fn.Name == "block" {
+		//block is tested with 'if block' in mixins, and is not defined outside mixins.
+		expr, typ = "nil", anyType
+	} else {
+		var ok bool
+		if expr, typ, ok = this.member(node, "data", this.data, fn.Name); !ok {
+			this.errorf(node, "Variable %q not defined on %s, globals is not supported in generated templates.", fn.Name, this.typeString(this.data))
+		}
+	}
+	expr, typ = this.index(node, expr, typ, fn.Index)
+	for next := fn.Next; next != nil; next = next.Next {
+		var ok bool
+		prevtype := typ
+		if next.IsIdentity {
+			if expr, typ, ok = this.member(node, expr, typ, next.Name); !ok {
+				this.errorf(node, "Variable %q not defined on %s.", next.Name, this.typeString(prevtype))
+			}
+		} else if expr, typ, ok = this.call(node, expr, typ, next); !ok {
+			this.errorf(node, "Function %q not found on %s.", next.Name, this.typeString(prevtype))
+		}
+		expr, typ = this.index(node, expr, typ, next.Index)
+	}
+	return expr, typ
+}
+
+// member returns a expression for a exported field of a struct, a item of a map with
+// string keys or a mixin attribute, see getVariableValue. ok is false when the type has
+// no member with the name. Members read through a nil pointer is the zero value.
+func (this *generator) member(node *TreeNode, expr string, typ types.Type, name string) (string, types.Type, bool) {
+	if isLinearMap(typ) {
+		return expr + ".Get(" + strconv.Quote(name) + ")", anyType, true
+	}
+	if typ == voidType {
+		return "", nil, false
+	}
+	if ptr, ok := typ.Underlying().(*types.Pointer); ok {
+		if m, ok := ptr.Elem().Underlying().(*types.Map); ok {
+			if isStringType(m.Key()) && this.nameable(m.Elem()) {
+				value := this.temp("ptr")
+				check := fmt.Sprintf("%s := %s\nif %s == nil {\nreturn *new(%s)\n}\n", value, expr, value, this.typeName(node, m.Elem()))
+				return this.guarded(node, check, "(*"+value+")["+strconv.Quote(name)+"]", m.Elem()), m.Elem(), true
+			}
+			expr, typ = "(*"+expr+")", ptr.Elem()
+		}
+	}
+	if m, ok := typ.Underlying().(*types.Map); ok && isStringType(m.Key()) {
+		return expr + "[" + strconv.Quote(name) + "]", m.Elem(), true
+	}
+	obj, index, _ := types.LookupFieldOrMethod(typ, true, nil, name)
+	if field, ok := obj.(*types.Var); ok {
+		return this.field(node, expr, typ, index, field.Type()), field.Type(), true
+	}
+	return "", nil, false
+}
+
+// field returns a expression for a field found at the index of a struct, the embedded
+// fields of the index is followed. A field read through a nil pointer is the zero value
+// of the field, the same as the evaluator. Fields of types that can't be written in the
+// generated file is read without checking for nil pointers.
+func (this *generator) field(node *TreeNode, expr string, typ types.Type, index []int, fieldType types.Type) string {
+	check := new(bytes.Buffer)
+	for _, i := range index {
+		if ptr, ok := typ.Underlying().(*types.Pointer); ok {
+			if this.nameable(fieldType) && (expr != "data" || len(this.dataNew) == 0) {
+				value := this.temp("ptr")
+				fmt.Fprintf(check, "%s := %s\nif %s == nil {\nreturn *new(%s)\n}\n", value, expr, value, this.typeName(node, fieldType))
+				expr = value
+			}
+			typ = ptr.Elem()
+		}
+		field := typ.Underlying().(*types.Struct).Field(i)
+		expr, typ = expr+"."+field.Name(), field.Type()
+	}
+	return this.guarded(node, check.String(), expr, fieldType)
+}
+
+// guarded returns expr evaluated after the nil checks in check, as a func literal called at once.
+func (this *generator) guarded(node *TreeNode, check string, expr string, typ types.Type) string {
+	if len(check) == 0 {
+		return expr
+	}
+	return fmt.Sprintf("func() %s {\n%sreturn %s\n}()", this.typeName(node, typ), check, expr)
+}
+
+// call returns a expression calling a exported method of a value. ok is false when the
+// type has no method with the name.
+func (this *generator) call(node *TreeNode, expr string, typ types.Type, fn *FuncToken) (string, types.Type, bool) {
+	if typ == voidType {
+		return "", nil, false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(typ, true, nil, fn.Name)
+	method, ok := obj.(*types.Func)
+	if !ok {
+		return "", nil, false
+	}
+	sig := method.Type().(*types.Signature)
+	params := sig.Params()
+	numFixed := params.Len()
+	if sig.Variadic() {
+		numFixed--
+		if len(fn.Arguments) < numFixed {
+			this.errorf(node, "wrong number of args for %s: want at least %d got %d", fn.Name, numFixed, len(fn.Arguments))
+		}
+	} else if len(fn.Arguments) != numFixed {
+		this.errorf(node, "wrong number of args for %s: want %d got %d", fn.Name, numFixed, len(fn.Arguments))
+	}
+	args := make([]string, len(fn.Arguments))
+	for i, arg := range fn.Arguments {
+		var paramType types.Type
+		if i < numFixed {
+			paramType = params.At(i).Type()
+		} else {
+			paramType = params.At(numFixed).Type().(*types.Slice).Elem()
+		}
+		args[i] = this.convert(arg, paramType)
+	}
+	call := expr + "." + fn.Name + "(" + strings.Join(args, ", ") + ")"
+	results := sig.Results()
+	switch {
+	case results.Len() == 0:
+		return call, voidType, true
+	case results.Len() == 1:
+		return call, results.At(0).Type(), true
+	case results.Len() == 2 && types.Identical(results.At(1).Type(), goErrorType):
+		result := results.At(0).Type()
+		return fmt.Sprintf("func() %s {\nresult, err := %s\njadeparser.CheckError(%q, err)\nreturn result\n}()", this.typeName(node, result), call, fn.Name), result, true
+	}
+	this.errorf(node, "can't call method/function %q with %d results", fn.Name, results.Len())
+	return "", nil, false
+}
+
+// convert returns a expression for a value passed as a argument of type typ. Numbers is
+// converted, and values without a static type is asserted to the type, see validateType.
+func (this *generator) convert(node *TreeNode, typ types.Type) string {
+	expr, argType := this.value(node)
+	switch {
+	case isBasic(argType, types.IsUntyped) && isBasic(typ, types.IsConstType):
+		return expr
+	case types.AssignableTo(argType, typ):
+		return expr
+	case isNumberType(argType) && isNumberType(typ):
+		return this.typeName(node, typ) + "(" + expr + ")"
+	case isAny(argType):
+		return expr + ".(" + this.typeName(node, typ) + ")"
+	}
+	this.errorf(node, "Cannot use %s of type %s as %s.", node.String(), this.typeString(argType), this.typeString(typ))
+	return ""
+}
+
+func (this *generator) index(node *TreeNode, expr string, typ types.Type, index *TreeNode) (string, types.Type) {
+	if index == nil {
+		return expr, typ
+	}
+	if isLinearMap(typ) {
+		return expr + ".Get(" + this.textExpr(this.value(index)) + ")", anyType
+	}
+	if ptr, ok := typ.Underlying().(*types.Pointer); ok {
+		if array, ok := ptr.Elem().Underlying().(*types.Array); ok {
+			return expr + "[" + this.convert(index, types.Typ[types.Int]) + "]", array.Elem()
+		}
+	}
+	switch under := typ.Underlying().(type) {
+	case *types.Slice:
+		return expr + "[" + this.convert(index, types.Typ[types.Int]) + "]", under.Elem()
+	case *types.Array:
+		return expr + "[" + this.convert(index, types.Typ[types.Int]) + "]", under.Elem()
+	case *types.Map:
+		return expr + "[" + this.convert(index, under.Key()) + "]", under.Elem()
+	}
+	this.errorf(node, "Cannot index a value of type %s.", this.typeString(typ))
+	return "", nil
+}
+
+// typeName returns the Go name of a type in the generated file, and imports the packages of the type.
+func (this *generator) typeName(node *TreeNode, typ types.Type) string {
+	if !this.nameable(typ) {
+		this.errorf(node, "Type %s is not exported, and can't be written in the generated file.", this.typeString(typ))
+	}
+	return types.TypeString(typ, this.qualifier)
+}
+
+// typeString returns the name of a type in error messages.
+func (this *generator) typeString(typ types.Type) string {
+	return types.TypeString(typ, (*types.Package).Name)
+}
+
+// nameable reports if a type can be written in the generated file, types not exported
+// can only be written in their own package.
+func (this *generator) nameable(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil && !obj.Exported() && (this.pkg == nil || obj.Pkg().Path() != this.pkg.Path()) {
+			return false
+		}
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if !this.nameable(t.TypeArgs().At(i)) {
+				return false
+			}
+		}
+	case *types.Pointer:
+		return this.nameable(t.Elem())
+	case *types.Slice:
+		return this.nameable(t.Elem())
+	case *types.Array:
+		return this.nameable(t.Elem())
+	case *types.Map:
+		return this.nameable(t.Key()) && this.nameable(t.Elem())
+	case *types.Chan:
+		return this.nameable(t.Elem())
+	}
+	return true
+}
+
+// qualifier returns the name of a package in the generated file, and imports the package.
+func (this *generator) qualifier(pkg *types.Package) string {
+	if this.pkg != nil && pkg.Path() == this.pkg.Path() {
+		return ""
+	}
+	if name, ok := this.imports[pkg.Path()]; ok {
+		return name
+	}
+	for imp, name := range this.imports {
+		if name == pkg.Name() {
+			this.errorf(nil, "Packages %q and %q used by the generated file has the same name.", imp, pkg.Path())
+		}
+	}
+	this.imports[pkg.Path()] = pkg.Name()
+	return pkg.Name()
+}
+
+// writeImports writes the imports of the generated file, the standard library first.
+func (this *generator) writeImports(out *bytes.Buffer) {
+	imports := make([]string, 0, len(this.imports))
+	for imp := range this.imports {
+		imports = append(imports, imp)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if isStdPackage(imports[i]) != isStdPackage(imports[j]) {
+			return isStdPackage(imports[i])
+		}
+		return imports[i] < imports[j]
+	})
+	for i, imp := range imports {
+		if i > 0 && isStdPackage(imports[i-1]) && !isStdPackage(imp) {
+			out.WriteString("\n")
+		}
+		if name := this.imports[imp]; name != path.Base(imp) {
+			fmt.Fprintf(out, "\t%s %q\n", name, imp)
+		} else {
+			fmt.Fprintf(out, "\t%q\n", imp)
+		}
+	}
+}
+
+// isStdPackage reports if a import path is a package of the standard library, the first
+// element of other import paths is a domain name.
+func isStdPackage(imp string) bool {
+	return !strings.Contains(strings.SplitN(imp, "/", 2)[0], ".")
+}
@@ -0,0 +1,242 @@
+package jadeparser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+)
+
+// Functions used by the Go source generated with Generate. The functions work on
+// values without a static type, like the values of maps declared in a template and
+// the result of operators, the same way the evaluator does.
+
+// Location is the template and position of the statement a generated template renders,
+// used to locate errors.
+type Location struct {
+	Template string
+	Line     int
+	Column   int
+}
+
+// Set sets the location, and returns true so it can be set in the condition of a else if.
+func (this *Location) Set(template string, line int, column int) bool {
+	this.Template, this.Line, this.Column = template, line, column
+	return true
+}
+
+// RecoverError returns a panic in a generated template as the error of the render function.
+// Errors is returned as a TemplateError at the location of the statement that failed.
+func RecoverError(errp *error, at *Location) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	err, ok := e.(error)
+	if !ok {
+		err = fmt.Errorf("%v", e)
+	}
+	var terr *TemplateError
+	var werr *WriteError
+	if errors.As(err, &terr) || errors.As(err, &werr) || len(at.Template) == 0 {
+		*errp = err
+		return
+	}
+	*errp = &TemplateError{Name: at.Template, Line: at.Line, Column: at.Column, Message: err.Error(), Err: err}
+}
+
+// FlushWriter writes the buffered output to wr, and flushes wr when it is a http.Flusher.
+func FlushWriter(w *bufio.Writer, wr io.Writer) {
+	if err := w.Flush(); err != nil {
+		panic(&WriteError{err})
+	}
+	if flusher, ok := wr.(flushWriter); ok {
+		flusher.Flush()
+	}
+}
+
+// ToText returns the text written for a value, nil is written as a empty string.
+func ToText(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return valueToString(reflect.ValueOf(value))
+}
+
+// EscapeText returns the text written for a value with html escaped.
+func EscapeText(value interface{}) string {
+	return html.EscapeString(ToText(value))
+}
+
+// IsTrue reports if a value is true in a if statement, nil, zero and empty values is false.
+func IsTrue(value interface{}) bool {
+	result, _ := isTrue(reflect.ValueOf(value))
+	return result
+}
+
+// Equal reports if a case value equals a when value.
+func Equal(value interface{}, when interface{}) bool {
+	result, err := eq(runtimeValue(value).Interface(), runtimeValue(when).Interface())
+	if err != nil {
+		panic(fmt.Errorf("case: Error on When value. %v", err))
+	}
+	return result
+}
+
+// CallBuiltin calls a builtin function or operator, like upper or +.
+func CallBuiltin(name string, args ...interface{}) interface{} {
+	fn, ok := builtinFunction(name)
+	if !ok {
+		panic(fmt.Errorf("Function %q not found.", name))
+	}
+	result, err := callValues(reflect.ValueOf(fn), name, args)
+	if err != nil {
+		panic(fmt.Errorf("Error on function %q Error: %w", name, err))
+	}
+	return result
+}
+
+// CheckError panics with the error returned by a method called from a generated template.
+func CheckError(name string, err error) {
+	if err != nil {
+		panic(fmt.Errorf("error calling %s: %w", name, err))
+	}
+}
+
+// NewAttributes returns the attributes passed to a mixin, from a list of key value pairs.
+func NewAttributes(keyvalues ...interface{}) *LinearMap {
+	result := &LinearMap{make(map[string]interface{}), make([]string, 0)}
+	for i := 0; i+1 < len(keyvalues); i += 2 {
+		result.Set(ToText(keyvalues[i]), keyvalues[i+1])
+	}
+	return result
+}
+
+// AttributeText returns the html of a attribute. Bool values writes the attribute
+// name when true, and nothing when false.
+func AttributeText(key string, value interface{}, escape bool, doctype string) string {
+	if val, ok := value.(bool); ok {
+		if !val {
+			return ""
+		}
+		if doctype == "html" {
+			return " " + key
+		}
+		return " " + key + "=\"" + key + "\""
+	}
+	if escape {
+		return " " + key + "=\"" + EscapeText(value) + "\""
+	}
+	return " " + key + "=\"" + ToText(value) + "\""
+}
+
+// IterItem is a item of a value iterated with a each statement.
+type IterItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Iterate returns the items of a array, slice, map or LinearMap, and the numbers
+// from zero for a number.
+func Iterate(value interface{}) []IterItem {
+	rvalue := reflect.ValueOf(value)
+	if value == nil {
+		panic(fmt.Errorf("value after 'each in' cannot be nil."))
+	}
+	if list, ok := value.(*LinearMap); ok {
+		result := make([]IterItem, 0, len(list.keys))
+		for _, k := range list.keys {
+			result = append(result, IterItem{k, list.Get(k)})
+		}
+		return result
+	}
+	switch rvalue.Kind() {
+	case reflect.Array, reflect.Slice:
+		result := make([]IterItem, rvalue.Len())
+		for i := range result {
+			result[i] = IterItem{i, runtimeInterface(rvalue.Index(i))}
+		}
+		return result
+	case reflect.Map:
+		result := make([]IterItem, 0, rvalue.Len())
+		for _, key := range rvalue.MapKeys() {
+			result = append(result, IterItem{key.Interface(), runtimeInterface(rvalue.MapIndex(key))})
+		}
+		return result
+	case reflect.Ptr:
+		if rvalue.IsNil() {
+			panic(fmt.Errorf("value after 'each in' cannot be nil."))
+		}
+		return Iterate(rvalue.Elem().Interface())
+	}
+	if rvalue = toCommonType(rvalue); rvalue.Kind() == reflect.Float64 {
+		cnt, err := iterationCount(rvalue.Float())
+		if err != nil {
+			panic(err)
+		}
+		result := make([]IterItem, cnt)
+		for i := range result {
+			result[i] = IterItem{i, i}
+		}
+		return result
+	}
+	panic(fmt.Errorf("Invalid value type after 'in' keyword, expecting an array, map or number found %s", rvalue.Kind()))
+}
+
+// callValues calls a function with values, converted to the types of the parameters
+// the same as arguments of functions called from a template.
+func callValues(fun reflect.Value, name string, args []interface{}) (result interface{}, err error) {
+	defer errRecover(&err)
+	typ := fun.Type()
+	if !goodFunc(typ) {
+		return nil, fmt.Errorf("can't call method/function %q with %d results", name, typ.NumOut())
+	}
+	numFixed := typ.NumIn()
+	if typ.IsVariadic() {
+		numFixed--
+		if len(args) < numFixed {
+			return nil, fmt.Errorf("wrong number of args for %s: want at least %d got %d", name, numFixed, len(args))
+		}
+	} else if len(args) != numFixed {
+		return nil, fmt.Errorf("wrong number of args for %s: want %d got %d", name, numFixed, len(args))
+	}
+	argv := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var argType reflect.Type
+		if i < numFixed {
+			argType = typ.In(i)
+		} else {
+			argType = typ.In(numFixed).Elem()
+		}
+		argv[i], err = validateType(runtimeValue(arg), argType)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q %v", argType.Name(), err)
+		}
+	}
+	fnresult := fun.Call(argv)
+	if len(fnresult) == 0 {
+		return nil, nil
+	}
+	if len(fnresult) == 2 && !fnresult[1].IsNil() {
+		return nil, fmt.Errorf("error calling %s: %w", name, fnresult[1].Interface().(error))
+	}
+	return runtimeInterface(fnresult[0]), nil
+}
+
+// runtimeValue returns a value converted to the types used by the evaluator, numbers is float64.
+func runtimeValue(value interface{}) reflect.Value {
+	if value == nil {
+		return reflect.Value{}
+	}
+	return toCommonType(reflect.ValueOf(value))
+}
+
+// runtimeInterface returns the value of a reflect value, nil for values not defined.
+func runtimeInterface(value reflect.Value) interface{} {
+	if isNullValue(value) || !value.CanInterface() {
+		return nil
+	}
+	return value.Interface()
+}
@@ -0,0 +1,92 @@
+package jadeparser
+
+import (
+	gotoken "go/token"
+	"go/types"
+	"reflect"
+)
+
+// The types of the values in generated templates is go/types types, found from the
+// type of the data parameter.
+
+const jadeparserPath = "github.com/zdebeer99/gojade/jadeparser"
+
+var (
+	//anyType is the type of values without a static type.
+	anyType types.Type = types.NewInterfaceType(nil, nil).Complete()
+	//voidType is the type of a call to a method without results.
+	voidType = types.NewTuple()
+	//blockType is the type of the block passed to a mixin.
+	blockType = types.NewSignatureType(nil, nil, nil, nil, nil, false)
+	//linearMapType is the type of mixin attributes and maps declared in a template.
+	linearMapType = types.NewPointer(types.NewNamed(
+		types.NewTypeName(gotoken.NoPos, types.NewPackage(jadeparserPath, "jadeparser"), "LinearMap", nil),
+		types.NewStruct(nil, nil), nil))
+	goErrorType = types.Universe.Lookup("error").Type()
+)
+
+// basicKinds is the go/types kinds of the results of builtin functions.
+var basicKinds = map[reflect.Kind]types.BasicKind{
+	reflect.Bool:    types.Bool,
+	reflect.Int:     types.Int,
+	reflect.Float64: types.Float64,
+	reflect.String:  types.String,
+}
+
+// resultType returns the type of the result of a builtin function, anyType when the
+// result is not a basic type.
+func resultType(fn interface{}) types.Type {
+	typ := reflect.TypeOf(fn)
+	if typ.NumOut() == 0 {
+		return anyType
+	}
+	out := typ.Out(0)
+	if kind, ok := basicKinds[out.Kind()]; ok && out.PkgPath() == "" && out.Name() == out.Kind().String() {
+		return types.Typ[kind]
+	}
+	return anyType
+}
+
+func isBasic(typ types.Type, info types.BasicInfo) bool {
+	basic, ok := typ.Underlying().(*types.Basic)
+	return ok && basic.Info()&info != 0
+}
+
+func isStringType(typ types.Type) bool {
+	return isBasic(typ, types.IsString)
+}
+
+func isNumberType(typ types.Type) bool {
+	return isBasic(typ, types.IsInteger|types.IsFloat)
+}
+
+func isBoolType(typ types.Type) bool {
+	return isBasic(typ, types.IsBoolean)
+}
+
+// isAny reports if a value has no static type, the type is a interface without methods.
+func isAny(typ types.Type) bool {
+	iface, ok := typ.Underlying().(*types.Interface)
+	return ok && iface.Empty()
+}
+
+func isLinearMap(typ types.Type) bool {
+	ptr, ok := typ.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == jadeparserPath && named.Obj().Name() == "LinearMap"
+}
+
+// defaultValue returns constants as a value of their default type, numbers is float64
+// the same as numbers in the evaluator.
+func defaultValue(expr string, typ types.Type) (string, types.Type) {
+	if !isBasic(typ, types.IsUntyped) {
+		return expr, typ
+	}
+	if isNumberType(typ) {
+		return "float64(" + expr + ")", types.Typ[types.Float64]
+	}
+	return expr, types.Default(typ)
+}
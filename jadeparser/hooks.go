@@ -0,0 +1,82 @@
+package jadeparser
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is a render, include, extends, mixin or function call reported to the observers.
+type Event struct {
+	Kind     string //"render", "include", "extends", "mixin" or "function"
+	Name     string //Name of the template, mixin or function called.
+	Template string //Template the call was made from, empty for a render.
+	Start    time.Time
+	Duration time.Duration //Set when the call is done.
+	Err      error         //Set when the call failed.
+}
+
+// Observer is notified before and after each render, include, extends, mixin call and
+// registered function call, to collect metrics or tracing spans. The same Event is
+// passed to Before and After. A extends event is reported for each template of the extends
+// chain, and lasts until the templates it extends is rendered. Observers is called from the
+// goroutine rendering the template and should be safe to call from multiple goroutines.
+type Observer interface {
+	Before(event *Event)
+	After(event *Event)
+}
+
+// beforeEvent reports the start of a call, and returns nil when there is no observers.
+func (this *EvalJade) beforeEvent(kind string, name string) *Event {
+	if len(this.Observers) == 0 {
+		return nil
+	}
+	event := &Event{Kind: kind, Name: name, Start: time.Now()}
+	if kind != "render" {
+		if part := this.currentPart(); part != nil {
+			event.Template = part.Name
+		}
+	}
+	for _, observer := range this.Observers {
+		observer.Before(event)
+	}
+	return event
+}
+
+// afterEvent reports the end of a call, event can be nil.
+func (this *EvalJade) afterEvent(event *Event, err error) {
+	if event == nil {
+		return
+	}
+	event.Duration = time.Since(event.Start)
+	event.Err = err
+	for _, observer := range this.Observers {
+		observer.After(event)
+	}
+}
+
+// afterRender reports the end of a render, it must be deferred before recover so the error is set.
+func (this *EvalJade) afterRender(event *Event, errp *error) {
+	this.afterEvent(event, *errp)
+}
+
+// observe calls fn and reports the call to the observers. A panic is reported as the error of
+// the call and then continues.
+func (this *EvalJade) observe(kind string, name string, fn func()) {
+	event := this.beforeEvent(kind, name)
+	if event == nil {
+		fn()
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(error)
+			if !ok {
+				err = fmt.Errorf("%v", e)
+			}
+			this.afterEvent(event, err)
+			panic(e)
+		}
+	}()
+	fn()
+	this.afterEvent(event, nil)
+}
@@ -1,5 +1,143 @@
 package jadeparser
 
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
 type VariableNotDefined struct {
 	error
 }
+
+// Warning is a problem found in a template that does not stop rendering, like an undefined variable.
+type Warning struct {
+	Name    string //Name of the template the warning was found in.
+	Line    int
+	Column  int
+	Source  string //The source line the warning was found on.
+	Message string
+}
+
+// newWarning creates a Warning for the position pos in the source.
+func newWarning(name string, source []byte, pos int, message string) *Warning {
+	err := newTemplateError(name, source, pos, message)
+	return &Warning{err.Name, err.Line, err.Column, err.Source, err.Message}
+}
+
+func (this *Warning) String() string {
+	buf := new(bytes.Buffer)
+	if len(this.Name) > 0 {
+		fmt.Fprintf(buf, "Template %q ", this.Name)
+	}
+	if this.Line > 0 {
+		fmt.Fprintf(buf, "Line %v Column %v ", this.Line, this.Column)
+	}
+	buf.WriteString("Warning: " + this.Message)
+	return buf.String()
+}
+
+// WriteError is returned when writing the output failed, for example when the client
+// disconnected. Rendering stops at the first write error. Output is buffered, a write error
+// is reported when the buffer is written to the writer, when the buffer is full, at a flush
+// point or when rendering is done, not at the write that failed.
+// Use errors.As to tell write errors apart from errors in the template.
+type WriteError struct {
+	Err error
+}
+
+func (this *WriteError) Error() string {
+	return "Error writing template output: " + this.Err.Error()
+}
+
+func (this *WriteError) Unwrap() error {
+	return this.Err
+}
+
+// TemplateError is returned for errors found while parsing or rendering a template.
+// Use errors.As to get the TemplateError from an error returned by the render methods.
+type TemplateError struct {
+	Name    string //Name of the template the error was found in.
+	Line    int
+	Column  int
+	Source  string //The source line the error was found on.
+	Message string
+	Stack   []StackFrame //The extends, include, block and mixin calls that led to the error. Outer most call first.
+	Err     error        //The underlying error if any.
+}
+
+// StackFrame is a extends, include, block or mixin call made while rendering a template.
+type StackFrame struct {
+	Kind     string //"extends", "include", "block" or "mixin"
+	Name     string //Name of the file, block or mixin called.
+	Template string //Template the call was made from.
+	Line     int
+}
+
+// newTemplateError creates a TemplateError for the position pos in the source.
+func newTemplateError(name string, source []byte, pos int, message string) *TemplateError {
+	err := &TemplateError{Name: name, Message: message}
+	err.setPosition(source, pos)
+	return err
+}
+
+// setPosition sets the line, column and source line from a position in the source.
+func (this *TemplateError) setPosition(source []byte, pos int) {
+	if pos > len(source) {
+		pos = len(source)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	start := bytes.LastIndexByte(source[:pos], '\n') + 1
+	end := bytes.IndexByte(source[pos:], '\n')
+	if end < 0 {
+		end = len(source)
+	} else {
+		end += pos
+	}
+	this.Line = 1 + bytes.Count(source[:pos], []byte("\n"))
+	this.Column = 1 + utf8.RuneCount(source[start:pos])
+	this.Source = strings.TrimRight(string(source[start:end]), "\r")
+}
+
+func (this *TemplateError) Error() string {
+	buf := new(bytes.Buffer)
+	if len(this.Name) > 0 {
+		fmt.Fprintf(buf, "Template %q ", this.Name)
+	}
+	if this.Line > 0 {
+		fmt.Fprintf(buf, "Line %v Column %v ", this.Line, this.Column)
+	}
+	buf.WriteString(this.Message)
+	for i := len(this.Stack) - 1; i >= 0; i-- {
+		frame := this.Stack[i]
+		fmt.Fprintf(buf, ", in %s %q called from %q Line %v", frame.Kind, frame.Name, frame.Template, frame.Line)
+	}
+	return buf.String()
+}
+
+func (this *TemplateError) Unwrap() error {
+	return this.Err
+}
+
+// Snippet returns the source line the error was found on, with a caret
+// pointing to the column on the next line.
+func (this *TemplateError) Snippet() string {
+	if len(this.Source) == 0 {
+		return ""
+	}
+	indent := make([]rune, 0, this.Column)
+	for i, r := range []rune(this.Source) {
+		if i >= this.Column-1 {
+			break
+		}
+		if r == '\t' {
+			indent = append(indent, '\t')
+		} else {
+			indent = append(indent, ' ')
+		}
+	}
+	return this.Source + "\n" + string(indent) + "^"
+}
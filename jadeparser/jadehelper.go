@@ -1,6 +1,8 @@
 package jadeparser
 
 import (
+	"bytes"
+	"encoding/json"
 	"reflect"
 )
 
@@ -41,6 +43,11 @@ func (this *ContextStack) Set(name string, value interface{}) {
 	this.stack[this.top][name] = toReflectValue(value)
 }
 
+// SetValue sets a value on the current scope.
+func (this *ContextStack) SetValue(name string, value reflect.Value) {
+	this.stack[this.top][name] = value
+}
+
 // SetGlobal Set a value on the global scope.
 func (this *ContextStack) SetGlobal(name string, value reflect.Value) {
 	this.stack[0][name] = value
@@ -96,3 +103,26 @@ func (this *LinearMap) Get(key string) interface{} {
 func (this *LinearMap) Keys() []string {
 	return this.keys
 }
+
+//MarshalJSON writes the map as a json object with the keys in the order they was added.
+func (this *LinearMap) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("{")
+	for i, key := range this.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		b, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteString(":")
+		if b, err = json.Marshal(this.index[key]); err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package jadeparser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrLimitExceeded is returned when rendering a template exceeds one of the Limits.
+// Use errors.Is(err, ErrLimitExceeded) to test for it.
+var ErrLimitExceeded = errors.New("Template limit exceeded")
+
+// Limits restricts the resources a template can use while rendering, to protect
+// against templates recursing or looping forever. A zero value means no limit, except
+// MaxDepth where zero is DefaultMaxDepth.
+type Limits struct {
+	MaxDepth      int   //Maximum nesting of extends, include, block and mixin calls, DefaultMaxDepth when 0.
+	MaxIterations int   //Maximum iterations of all each loops in a render, nested loops counts every iteration.
+	MaxOutput     int64 //Maximum number of bytes written.
+	MaxSteps      int   //Maximum number of nodes evaluated.
+}
+
+// limitf terminates processing with an ErrLimitExceeded error.
+func (this *EvalJade) limitf(node *TreeNode, format string, args ...interface{}) {
+	this.errorf(node, "%v: "+format, append([]interface{}{ErrLimitExceeded}, args...)...)
+}
+
+// step counts a evaluated node.
+func (this *EvalJade) step(node *TreeNode) {
+	this.countSteps(node, 1)
+}
+
+// countSteps counts the nodes written at once by a execution plan, node is the first node.
+func (this *EvalJade) countSteps(node *TreeNode, count int) {
+	this.steps += count
+	if this.Limits.MaxSteps > 0 && this.steps > this.Limits.MaxSteps {
+		this.limitf(node, "more than %v nodes evaluated.", this.Limits.MaxSteps)
+	}
+}
+
+// DefaultMaxDepth is the nesting of calls allowed when Limits.MaxDepth is 0. Deeper
+// calls is usually a mixin calling itself without end, that would exceed the stack of
+// the goroutine and terminate the program.
+const DefaultMaxDepth = 1000
+
+// checkDepth checks the nesting of calls before a call is made.
+func (this *EvalJade) checkDepth(kind string, name string) {
+	maxDepth := this.Limits.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if len(this.callstack) >= maxDepth {
+		this.limitf(nil, "%s %q nested deeper than %v calls.", kind, name, maxDepth)
+	}
+}
+
+// checkIterations adds the iterations of a loop to the iterations of the render
+// before the loop is started.
+func (this *EvalJade) checkIterations(node *TreeNode, count int) {
+	if count < 0 {
+		count = 0
+	}
+	this.iterations += count
+	if this.Limits.MaxIterations > 0 && this.iterations > this.Limits.MaxIterations {
+		this.limitf(node, "each loops of %v iterations exceeds the maximum of %v.", this.iterations, this.Limits.MaxIterations)
+	}
+}
+
+// iterationCount returns the iterations of a each loop over a number, negative numbers
+// is 0 iterations. Numbers that is not a valid int, like 1e20, returns a error.
+func iterationCount(count float64) (int, error) {
+	if count <= 0 {
+		return 0, nil
+	}
+	if math.IsNaN(count) || count >= math.MaxInt {
+		return 0, fmt.Errorf("%v is not a valid number of iterations after 'each in'.", count)
+	}
+	return int(count), nil
+}
+
+// checkOutput checks the output size before size bytes is written.
+func (this *EvalJade) checkOutput(size int) {
+	if this.Limits.MaxOutput > 0 && this.writer.written+int64(size) > this.Limits.MaxOutput {
+		this.limitf(nil, "output exceeds the maximum of %v bytes.", this.Limits.MaxOutput)
+	}
+}
+
+// enterFile adds a template to the files currently rendered, a template included
+// or extended while it is rendered is a cycle that never ends.
+func (this *EvalJade) enterFile(template *Template) {
+	for _, path := range this.files {
+		if path == template.Path {
+			kind := "include"
+			if len(this.callstack) > 0 {
+				kind = this.callstack[len(this.callstack)-1].Kind
+			}
+			this.errorf(nil, "Template %q is a cyclic %s, the template is already rendered.", template.Name, kind)
+		}
+	}
+	this.files = append(this.files, template.Path)
+}
+
+func (this *EvalJade) exitFile() {
+	this.files = this.files[:len(this.files)-1]
+}
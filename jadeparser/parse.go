@@ -22,6 +22,8 @@ type parser struct {
 	mixins       map[string]*TreeNode
 	blocks       map[string]*TreeNode
 	extends      string
+	input        string
+	warnings     []*Warning
 }
 
 type ParseResult struct {
@@ -31,18 +33,22 @@ type ParseResult struct {
 	Mixins  map[string]*TreeNode
 	Blocks  map[string]*TreeNode
 	Extends string
+	//Warnings found while parsing, the same warnings is also in Log.
+	Warnings []*Warning
 }
 
 func NewParser(input string) *parser {
 	root := NewTreeNode(NewEmptyToken())
 	return &parser{scanner.NewScanner(input), root, root, nil, make([]string, 0), nil, new(indent), 0, make(map[string]*TreeNode), make(map[string]*TreeNode),
-		""}
+		"", input, make([]*Warning, 0)}
 }
 
 func Parse(input string) *ParseResult {
 	parse := NewParser(input)
 	parse.pumpJade(branchStartStatement)
-	return &ParseResult{parse.root, parse.err, parse.log, parse.mixins, parse.blocks, parse.extends}
+	result := &ParseResult{parse.root, parse.err, parse.log, parse.mixins, parse.blocks, parse.extends, parse.warnings}
+	compilePlans(result)
+	return result
 }
 
 func ParseExpression(input string) (*TreeNode, error) {
@@ -130,6 +136,7 @@ func (this *parser) error(err interface{}, a ...interface{}) {
 			errortxt = err.(string)
 		}
 	}
+	pos := this.scan.StartPosition()
 	lasttoken := this.commit()
 	if len(lasttoken) < 10 {
 		for i := len(lasttoken); i < 10 && !this.scan.IsEOF(); i++ {
@@ -137,7 +144,7 @@ func (this *parser) error(err interface{}, a ...interface{}) {
 		}
 		lasttoken = lasttoken + this.commit()
 	}
-	debug := fmt.Errorf("Line: %v, near %q, Error: %s", this.scan.LineNumber(), lasttoken, errortxt)
+	debug := newTemplateError("", []byte(this.input), pos, fmt.Sprintf("near %q, Error: %s", lasttoken, errortxt))
 	this.add(NewErrorToken(debug.Error()))
 	this.err = debug
 }
@@ -155,6 +162,7 @@ func (this *parser) warning(warning interface{}, a ...interface{}) {
 	}
 	debug := fmt.Sprintf("Line: %v, Warning: %s", this.scan.LineNumber(), warningtxt)
 	this.log = append(this.log, debug)
+	this.warnings = append(this.warnings, newWarning("", []byte(this.input), this.scan.StartPosition(), warningtxt))
 }
 
 func (this *parser) commit() string {
@@ -197,9 +205,10 @@ func (this *parser) parseCloseBracket() stateFn {
 
 func (this *parser) parseIdentity() {
 	scan := this.scan
+	pos := scan.StartPosition()
 	identityName := this.commit()
 	if strings.ToLower(identityName) == "true" || strings.ToLower(identityName) == "false" {
-		this.add(NewBoolToken(identityName))
+		this.add(NewBoolToken(identityName)).Pos = pos
 		return
 	}
 	placeholder := NewIdentityToken("placeholder")
@@ -247,7 +256,7 @@ loop:
 		scan.Backup()
 		break loop
 	}
-	this.add(placeholder.Next)
+	this.add(placeholder.Next).Pos = pos
 }
 
 func (this *parser) parseFunctionArguments(ftoken *FuncToken) {
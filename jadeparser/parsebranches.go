@@ -280,11 +280,12 @@ func branchAttributeEnd(this *parser) stateFn {
 }
 
 func branchCode(this *parser) stateFn {
+	pos := this.scan.StartPosition()
 	switch this.commit() {
 	case "=":
 		fnescapeHtml := NewFuncToken(escapeHtmlFunc)
 		fnescapeHtml.AddArgument(this.parseExpression())
-		this.add(fnescapeHtml)
+		this.add(fnescapeHtml).Pos = pos
 	case "!=":
 		this.curr.AddElement(this.parseExpression())
 	case "-":
@@ -293,6 +294,7 @@ func branchCode(this *parser) stateFn {
 		getMixin := NewFuncToken(jadeMixinFunc)
 		getMixin.AddArgument(this.parseExpression())
 		this.replace(getMixin)
+		this.curr.Pos = pos
 	default:
 		this.error("Unexpected char")
 	}
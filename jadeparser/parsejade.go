@@ -3,9 +3,10 @@ package jadeparser
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
-var keywords []string = []string{"if", "else", "unless", "case", "when", "default", "each", "mixin", "block", "extends", "include"}
+var keywords []string = []string{"if", "else", "unless", "case", "when", "default", "each", "mixin", "block", "extends", "include", "flush"}
 
 var selfClosingTags = []string{
 	"meta",
@@ -61,6 +62,10 @@ func (this *parser) parseIndent() bool {
 		this.unstack(this.curr.Depth() - lvl)
 		this.curr = this.add(tag)
 	}
+	if isFlushKeyword(this.curr.parent) {
+		this.error("Unexpected content nested in 'flush', the flush keyword cannot have nested content.")
+		return false
+	}
 	return true
 }
 
@@ -76,6 +81,11 @@ func (this *parser) parseHtmlTag() stateFn {
 		this.replace(NewHtmlDocTypeToken())
 		return branchHtmlDocType
 	}
+	//flush is only a keyword alone on its line, flush followed by text or attributes is a tag.
+	if tagname == "flush" && !this.atLineEnd() {
+		tag.TagName = tagname
+		return branchAfterHtmlTag
+	}
 	if state := this.parseKeyword(tagname); state != nil {
 		return state
 	}
@@ -86,6 +96,30 @@ func (this *parser) parseHtmlTag() stateFn {
 	return branchAfterHtmlTag
 }
 
+func isFlushKeyword(node *TreeNode) bool {
+	if node == nil {
+		return false
+	}
+	fn, ok := node.Value.(*FuncToken)
+	return ok && fn.Name == "flush"
+}
+
+// atLineEnd reports if only spaces follows on the line, the position of the scanner is not changed.
+func (this *parser) atLineEnd() bool {
+	state := this.scan.SaveState()
+	defer this.scan.LoadState(state)
+	for !this.scan.IsEOF() {
+		switch this.scan.Next() {
+		case ' ', '\t':
+		case '\r', '\n':
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func (this *parser) parseBlockExpansion() stateFn {
 	this.curr = this.stack(NewHtmlTagToken("div"))
 	return this.parseHtmlTag()
@@ -209,6 +243,8 @@ func (this *parser) parseComment() stateFn {
 func (this *parser) parseKeyword(keyword string) stateFn {
 	if InSlice(keywords, keyword) {
 		fnkeywork := NewFuncToken(keyword)
+		//keep the position of the keyword, replace moves it past the arguments.
+		pos := this.curr.Pos
 		var arg *TreeNode
 		var blockExpandsion bool
 
@@ -217,10 +253,11 @@ func (this *parser) parseKeyword(keyword string) stateFn {
 		case "when", "default":
 			if endo := this.scan.RunTo(":\n"); endo != -1 {
 				blockExpandsion = endo == ':'
+				exprpos := this.scan.StartPosition()
 				exprtxt := this.scan.Commit()
 				exprtxt = exprtxt[:len(exprtxt)-1]
 				if len(exprtxt) > 0 {
-					arg = this.parseExpressionFrom(exprtxt)
+					arg = this.parseExpressionFrom(exprtxt, exprpos)
 				} else {
 					arg = nil
 				}
@@ -234,14 +271,16 @@ func (this *parser) parseKeyword(keyword string) stateFn {
 				this.error("Expecting a filename after the keyword '%s'", keyword)
 				return branchEnd
 			}
+			txttoken.Text = strings.TrimSpace(txttoken.Text)
 			this.extends = txttoken.Text
 		case "include":
 			arg = this.getContent()
-			_, ok := arg.Value.(*TextToken)
+			txttoken, ok := arg.Value.(*TextToken)
 			if !ok {
 				this.error("Expecting a filename after the keyword '%s'", keyword)
 				return branchEnd
 			}
+			txttoken.Text = strings.TrimSpace(txttoken.Text)
 		default:
 			arg = this.parseExpression()
 			//handle 'else if'
@@ -264,6 +303,7 @@ func (this *parser) parseKeyword(keyword string) stateFn {
 			fnkeywork.AddArgument(arg)
 		}
 		this.replace(fnkeywork)
+		this.curr.Pos = pos
 
 		//Validation and Special cases
 		switch keyword {
@@ -462,12 +502,14 @@ func (this *parser) parseExpression() *TreeNode {
 	return expr
 }
 
-func (this *parser) parseExpressionFrom(expr string) *TreeNode {
+// parseExpressionFrom parses an expression found at position pos of the input.
+func (this *parser) parseExpressionFrom(expr string, pos int) *TreeNode {
 	node, err := ParseExpression(expr)
 	if err != nil {
 		this.error("Expression Error %s", err.Error())
 		return nil
 	}
+	offsetPos(node, pos)
 	if len(node.items) == 0 {
 		node = nil
 	} else if len(node.items) == 1 {
@@ -643,6 +685,7 @@ func (this *parser) getContent() *TreeNode {
 	var buf, code bytes.Buffer
 	var node = this.newNode(NewEmptyToken())
 	var inCode, escape bool
+	var codepos int
 	for {
 		this.ignore()
 		if this.scan.AcceptNewLine() || this.scan.IsEOF() {
@@ -663,10 +706,11 @@ func (this *parser) getContent() *TreeNode {
 			node.AddElement(this.newNode(NewTextToken(buf.String())))
 			buf.Reset()
 			inCode = true
+			codepos = this.scan.Position()
 		}
 		r := this.scan.Next()
 		if r == '}' && inCode {
-			expr := this.parseExpressionFrom(code.String())
+			expr := this.parseExpressionFrom(code.String(), codepos)
 			if expr != nil {
 				if escape {
 					fnescapeHtml := NewFuncToken(escapeHtmlFunc)
@@ -695,6 +739,28 @@ func (this *parser) getContent() *TreeNode {
 	return node
 }
 
+// offsetPos moves the position of every node in a expression tree by offset.
+func offsetPos(node *TreeNode, offset int) {
+	if node == nil {
+		return
+	}
+	node.Pos += offset
+	switch val := node.Value.(type) {
+	case *FuncToken:
+		for fn := val; fn != nil; fn = fn.Next {
+			for _, arg := range fn.Arguments {
+				offsetPos(arg, offset)
+			}
+			offsetPos(fn.Index, offset)
+		}
+	case *KeyValueToken:
+		offsetPos(val.Value, offset)
+	}
+	for _, item := range node.items {
+		offsetPos(item, offset)
+	}
+}
+
 func InSlice(slice []string, value string) bool {
 	for _, v := range slice {
 		if v == value {
@@ -2,7 +2,9 @@ package jadeparser
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -27,7 +29,7 @@ func TestVerifyJade(t *testing.T) {
 	if testonly > -1 {
 		t.Logf("Testing only %v. %s", testonly, validation[testonly].name)
 		result := Parse(validation[testonly].jade)
-		t.Logf(result.Root.String())
+		t.Log(result.Root.String())
 		if result.Err != nil {
 			t.Error(result.Err)
 		}
@@ -61,7 +63,9 @@ func TestEvalExtends(t *testing.T) {
 	buf := new(bytes.Buffer)
 	eval := NewEvalJade(buf)
 	eval.SetViewPath("../res/extends")
-	eval.RenderFile("index.jade")
+	if err := eval.RenderFile("index.jade"); err != nil {
+		t.Error(err)
+	}
 	html := "<!DOCTYPE html><html><head><title>Article Title</title></head><body></body><h1>This Part is from the layout.jade file</h1><h2>This is from index.jade</h2></html>"
 	if buf.String() != html {
 		t.Errorf("Html does not match:\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
@@ -74,7 +78,9 @@ func TestEvalExpressions(t *testing.T) {
 	eval := NewEvalJade(buf)
 	eval.SetData(map[string]int{"x": 5, "y": 2, "youAreUsingJade": 1})
 	eval.SetViewPath("../res")
-	eval.RenderFile("expressions.jade")
+	if err := eval.RenderFile("expressions.jade"); err != nil {
+		t.Error(err)
+	}
 	if strings.Contains(buf.String(), "Error") {
 		t.Error("Found the word 'Error' in Html:\n" + buf.String())
 	}
@@ -128,8 +134,15 @@ func save(filename string, data []byte) {
 }
 
 // RenderJade shortcut function.
-func renderJade(buf *bytes.Buffer, template string, data interface{}) *EvalJade {
-	eval := NewEvalJade(buf)
+func renderJade(buf *bytes.Buffer, template string, data interface{}) (*EvalJade, error) {
+	eval := newTestEval(buf, data)
+	err := eval.RenderString(template)
+	return eval, err
+}
+
+// newTestEval creates a evaluator with the functions used by verifyjade.jade.
+func newTestEval(wr io.Writer, data interface{}) *EvalJade {
+	eval := NewEvalJade(wr)
 	eval.SetData(data)
 	eval.RegisterFunction("safeDivide", func(v1, v2 int) int {
 		if v2 == 0 {
@@ -143,7 +156,6 @@ func renderJade(buf *bytes.Buffer, template string, data interface{}) *EvalJade
 	eval.RegisterFunction("number5", func() string {
 		return "Five"
 	})
-	eval.RenderString(template)
 	return eval
 }
 
@@ -151,7 +163,10 @@ func renderJade(buf *bytes.Buffer, template string, data interface{}) *EvalJade
 func evaltest(t *testing.T, i int, item *verifyItem, data interface{}) {
 	buf := new(bytes.Buffer)
 	t.Logf("Testing %v. %s", i, item.name)
-	j := renderJade(buf, item.jade, data)
+	j, err := renderJade(buf, item.jade, data)
+	if err != nil {
+		t.Errorf("%v. %s Failed. %v", i, item.name, err)
+	}
 	if len(j.Log) > 0 {
 		for _, item := range j.Log {
 			t.Log(item)
@@ -236,3 +251,242 @@ func getPart(part *bytes.Buffer) string {
 	}
 	return ""
 }
+
+// verifyTemplate is a compiled example of verifyjade.jade.
+type verifyTemplate struct {
+	*CompiledTemplate
+	html string //The html expected by verifyjade.jade.
+}
+
+// compileVerifyJade compiles the examples in verifyjade.jade that renders without errors.
+func compileVerifyJade(tb testing.TB) []*verifyTemplate {
+	content, err := load("../res/verifyjade.jade")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	loader := NewMapLoader()
+	result := make([]*verifyTemplate, 0)
+	for i, item := range parseverifyfile(content) {
+		if len(item.jade) == 0 {
+			continue
+		}
+		name := fmt.Sprintf("verify%v.jade", i)
+		loader.Add(name, item.jade)
+		compiled, err := Compile(loader, name)
+		if err != nil {
+			continue
+		}
+		if err := newTestEval(ioutil.Discard, newVerifyStruct()).Execute(compiled); err != nil {
+			continue
+		}
+		result = append(result, &verifyTemplate{compiled, item.html})
+	}
+	return result
+}
+
+func newVerifyStruct() *VerifyStruct {
+	return &VerifyStruct{"Hello Jade", false, 5, 10, []int{32, 37, 38, 42}, &PersonModel{"Ben", 32}}
+}
+
+// verifyFailing is the examples in verifyjade.jade TestVerifyJade fails on.
+var verifyFailing = map[string]bool{"verify36.jade": true, "verify63.jade": true}
+
+// Test rendering with the execution plan writes the html expected by verifyjade.jade,
+// and the same html and number of steps as walking the tree, also when beautified.
+func TestExecutionPlan(t *testing.T) {
+	compiled := compileVerifyJade(t)
+	if len(compiled) < 50 {
+		t.Fatalf("Expecting at least 50 examples to compile, found %v", len(compiled))
+	}
+	for _, template := range compiled {
+		for _, beautify := range []bool{false, true} {
+			planned, walked := new(bytes.Buffer), new(bytes.Buffer)
+			plan := newTestEval(planned, newVerifyStruct())
+			plan.Beautify = beautify
+			err1 := plan.Execute(template.CompiledTemplate)
+			tree := newTestEval(walked, newVerifyStruct())
+			tree.Beautify = beautify
+			tree.treeWalk = true
+			err2 := tree.Execute(template.CompiledTemplate)
+			if !beautify && !verifyFailing[template.Name] && planned.String() != template.html {
+				t.Errorf("%s Failed. Html does not match.\nExpected:\n[%s]\nPlan:\n[%s]", template.Name, template.html, planned.String())
+			}
+			if fmt.Sprint(err1) != fmt.Sprint(err2) || planned.String() != walked.String() || plan.steps != tree.steps {
+				t.Errorf("%s Failed. Beautify %v. Html does not match.\nPlan:\n[%s] %v %v steps\nTree:\n[%s] %v %v steps", template.Name, beautify,
+					planned.String(), err1, plan.steps, walked.String(), err2, tree.steps)
+			}
+		}
+	}
+}
+
+// Test beautified output and limits is rendered with the execution plan.
+func TestExecutionPlanOptions(t *testing.T) {
+	loader := NewMapLoader()
+	loader.Add("page.jade", "html\n  body\n    h1.title Products\n    ul\n      each product in Products\n        li= product.Name\n    p done")
+	compiled, err := Compile(loader, "page.jade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := compiled.Root.Root.Root
+	content := root.content
+	var planned int
+	root.content = func(this *EvalJade) {
+		planned++
+		content(this)
+	}
+	data := map[string]interface{}{"Products": []*PersonModel{{"Tea", 1}, {"Milk", 2}}}
+	render := func(limits Limits, beautify, treeWalk bool) (*EvalJade, string, error) {
+		buf := new(bytes.Buffer)
+		eval := newTestEval(buf, data)
+		eval.Limits = limits
+		eval.Beautify = beautify
+		eval.treeWalk = treeWalk
+		err := eval.Execute(compiled)
+		return eval, buf.String(), err
+	}
+	cases := []struct {
+		name     string
+		limits   Limits
+		beautify bool
+		err      bool
+	}{
+		{"beautify", Limits{}, true, false},
+		{"limits", Limits{MaxSteps: 100, MaxOutput: 1000}, false, false},
+		{"beautify and limits", Limits{MaxSteps: 100, MaxOutput: 1000}, true, false},
+		{"max steps", Limits{MaxSteps: 8}, false, true},
+		{"max output", Limits{MaxOutput: 40}, true, true},
+	}
+	for _, c := range cases {
+		planned = 0
+		plan, html1, err1 := render(c.limits, c.beautify, false)
+		if planned != 1 {
+			t.Errorf("%s Failed. Expecting the template to be rendered with the execution plan.", c.name)
+		}
+		tree, html2, err2 := render(c.limits, c.beautify, true)
+		//static text is written at once, so the node the output limit is exceeded at can differ.
+		if c.err != errors.Is(err1, ErrLimitExceeded) || c.err != errors.Is(err2, ErrLimitExceeded) {
+			t.Errorf("%s Failed. Expecting limit error %v found\nPlan: %v\nTree: %v", c.name, c.err, err1, err2)
+		}
+		if !c.err && (html1 != html2 || plan.steps != tree.steps || err1 != nil) {
+			t.Errorf("%s Failed. Html does not match.\nPlan:\n[%s] %v steps\nTree:\n[%s] %v steps", c.name, html1, plan.steps, html2, tree.steps)
+		}
+	}
+}
+
+func benchmarkVerifyJade(b *testing.B, treeWalk bool) {
+	compiled := compileVerifyJade(b)
+	data := newVerifyStruct()
+	b.ReportAllocs()
+	b.ResetTimer()
+	evals := make([]*EvalJade, len(compiled))
+	for i := 0; i < b.N; i++ {
+		//only rendering is measured, a evaluator is created for each render.
+		b.StopTimer()
+		for j := range evals {
+			evals[j] = newTestEval(ioutil.Discard, data)
+			evals[j].treeWalk = treeWalk
+		}
+		b.StartTimer()
+		for j, template := range compiled {
+			if err := evals[j].Execute(template.CompiledTemplate); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Benchmark rendering the examples in verifyjade.jade with the execution plan.
+func BenchmarkExecutionPlan(b *testing.B) {
+	benchmarkVerifyJade(b, false)
+}
+
+// Benchmark rendering the examples in verifyjade.jade by walking the tree.
+func BenchmarkTreeWalk(b *testing.B) {
+	benchmarkVerifyJade(b, true)
+}
+
+type EmbeddedModel struct {
+	PersonModel
+	*AddressModel
+	Title string
+}
+
+type AddressModel struct {
+	City string
+}
+
+func (this AddressModel) Where() string {
+	return "in " + this.City
+}
+
+// Test fields and methods promoted from embedded structs.
+func TestEmbeddedStruct(t *testing.T) {
+	jade := "p #{Title} #{Name} #{Age} #{City} #{Where()}"
+	buf := new(bytes.Buffer)
+	eval, err := renderJade(buf, jade, &EmbeddedModel{PersonModel{"Ben", 32}, &AddressModel{"Paris"}, "Mr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := "<p>Mr Ben 32 Paris in Paris</p>"; buf.String() != html || len(eval.Log) > 0 {
+		t.Errorf("Html does not match.\nExpected:\n%s\nParsedTo:\n%s\n%v", html, buf.String(), eval.Log)
+	}
+	//a field promoted through a nil pointer is not defined.
+	buf.Reset()
+	eval, err = renderJade(buf, "p #{Name} #{City}", &EmbeddedModel{PersonModel: PersonModel{"Ben", 32}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := "<p>Ben </p>"; buf.String() != html {
+		t.Errorf("Html does not match.\nExpected:\n%s\nParsedTo:\n%s", html, buf.String())
+	}
+	if len(eval.Log) != 1 || !strings.Contains(eval.Log[0], `"City" not defined`) {
+		t.Errorf("Expecting a warning for City, found %v", eval.Log)
+	}
+	//strict mode reports the field as not defined.
+	eval = newTestEval(new(bytes.Buffer), &EmbeddedModel{PersonModel: PersonModel{"Ben", 32}})
+	eval.Strict = true
+	if err = eval.RenderString("p #{City}"); err == nil || !strings.Contains(err.Error(), `"City" not defined`) {
+		t.Errorf("Expecting City not defined error, found %v", err)
+	}
+}
+
+type NumberModel struct {
+	Count int
+	Big   int64
+	Ratio float32
+}
+
+// Test numbers of other types than float64 used in output, operators, loops and case statements.
+func TestNumberTypes(t *testing.T) {
+	jade := "p #{Count} #{Big} #{Ratio} #{Count + 1} #{Count * Ratio}\neach i in Count\n  i= i\ncase Count\n  when 2: b two\n  default: b other"
+	buf := new(bytes.Buffer)
+	eval, err := renderJade(buf, jade, &NumberModel{2, 1234567890123456789, 1.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := "<p>2 1234567890123456789 1.5 3 3</p><i>0</i><i>1</i><b>two</b>"; buf.String() != html || len(eval.Log) > 0 {
+		t.Errorf("Html does not match.\nExpected:\n%s\nParsedTo:\n%s\n%v", html, buf.String(), eval.Log)
+	}
+}
+
+// Benchmark a each loop reading the fields of a thousand structs.
+func BenchmarkEachStruct(b *testing.B) {
+	people := make([]*PersonModel, 1000)
+	for i := range people {
+		people[i] = &PersonModel{fmt.Sprintf("Person %v", i), i}
+	}
+	result := Parse("ul\n  each person in People\n    li #{person.Name} #{person.Age}")
+	if result.Err != nil {
+		b.Fatal(result.Err)
+	}
+	data := map[string]interface{}{"People": people}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		eval := NewEvalJade(ioutil.Discard)
+		eval.SetData(data)
+		if err := eval.Exec(result.Root); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
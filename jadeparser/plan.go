@@ -0,0 +1,565 @@
+package jadeparser
+
+import (
+	"fmt"
+	gotoken "go/token"
+	"html"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The execution plan of a template is compiled once after the template is parsed,
+// each node is given closures that renders the node or returns its value without
+// switching on the type of the token when the template is rendered.
+//
+// Text known when the template is parsed, like html tags, static attributes and
+// text, is concatenated and written at once. Operators and builtin functions is bound
+// to their function, and the values of constants is created once. Identities cache
+// the field or method index of the types they are used on, and registered functions
+// and methods of the data is found once per render. Nodes without a plan, like nodes
+// created while rendering, is rendered by walking the tree.
+
+// execFunc renders the content of a node.
+type execFunc func(this *EvalJade)
+
+// valueFunc returns the value of a node, see getValue.
+type valueFunc func(this *EvalJade) reflect.Value
+
+// planOp is a step in the plan of a node's content. A step writes static text,
+// evaluates a if, unless or else statement, or executes a closure.
+type planOp struct {
+	text    string
+	closes  []planClose //Closing tags in text, used to flush after FlushTags.
+	newline bool        //A new line is written after the text, when the output is beautified.
+	steps   int         //Number of nodes written by the text, see Limits.MaxSteps.
+	node    *TreeNode   //The first node written by the text, used to locate errors.
+	cond    *TreeNode   //A if, unless or else statement.
+	exec    execFunc
+}
+
+// planClose is the end of a closing tag in the text of a planOp.
+type planClose struct {
+	end int
+	tag string
+}
+
+// usePlan reports if nodes is rendered with their execution plan.
+func (this *EvalJade) usePlan() bool {
+	return !this.treeWalk
+}
+
+// compilePlans compiles the execution plan of a parsed template.
+func compilePlans(result *ParseResult) {
+	if result.Err != nil {
+		return
+	}
+	compilePlan(result.Root)
+	for _, node := range result.Mixins {
+		compilePlan(node)
+	}
+	for _, node := range result.Blocks {
+		compilePlan(node)
+	}
+}
+
+// compilePlan compiles the execution plan of a node and every node below it.
+func compilePlan(node *TreeNode) {
+	if node == nil || node.content != nil || node.value != nil {
+		return
+	}
+	for _, item := range node.items {
+		compilePlan(item)
+	}
+	switch val := node.Value.(type) {
+	case *FuncToken:
+		compileFuncPlan(val)
+	case *HtmlTagToken:
+		for _, attr := range val.Attributes {
+			compilePlan(attr)
+		}
+	case *KeyValueToken:
+		compilePlan(val.Value)
+	}
+	node.value = planValue(node)
+	if len(node.items) > 0 {
+		node.content = planContent(node)
+	}
+}
+
+func compileFuncPlan(fn *FuncToken) {
+	for ; fn != nil; fn = fn.Next {
+		for _, arg := range fn.Arguments {
+			compilePlan(arg)
+		}
+		compilePlan(fn.Index)
+	}
+}
+
+// planValue returns the closure returning the value of a node, nil for tokens without a value.
+func planValue(node *TreeNode) valueFunc {
+	switch val := node.Value.(type) {
+	case *NumberToken:
+		return constantValue(toReflectValue(val.Value))
+	case *TextToken:
+		return constantValue(toReflectValue(val.Text))
+	case *BoolToken:
+		return constantValue(toReflectValue(val.Value))
+	case *GroupToken:
+		return func(this *EvalJade) reflect.Value {
+			return this.getGroup(node, val)
+		}
+	case *OperatorToken:
+		return planOperator(node, val)
+	case *FuncToken:
+		if val.IsIdentity {
+			cache := newMemberCache(val)
+			return func(this *EvalJade) reflect.Value {
+				val1, _ := this.getIdentityValue(node, val, cache)
+				return val1
+			}
+		}
+		switch val.Name {
+		case escapeHtmlFunc:
+			if text, ok := constantEscapeHtml(val); ok {
+				return constantValue(toReflectValue(text))
+			}
+		case "mixin", "if", "unless", "else", "when", "default", "case", "var", "each",
+			jadeMixinFunc, jadeBlockFunc, "include", "extends", "flush":
+		default:
+			return planCall(node, val)
+		}
+		return func(this *EvalJade) reflect.Value {
+			return this.evalFunc(node, val)
+		}
+	}
+	return nil
+}
+
+// planCall binds a function call to its function, see evalFunc. Builtin functions is
+// bound when the plan is compiled, methods of the data cannot have the unexported
+// name of a builtin function. Other functions is found once per render.
+func planCall(node *TreeNode, token *FuncToken) valueFunc {
+	if fnvalue, ok := builtinFunction(token.Name); ok && !gotoken.IsExported(token.Name) {
+		fn := reflect.ValueOf(fnvalue)
+		return func(this *EvalJade) reflect.Value {
+			val1, err := this.callFunc(fn, token.Name, token.Arguments)
+			if err != nil {
+				this.errorf(node, "External function %q Error: %v", token.Name, err)
+			}
+			return val1
+		}
+	}
+	return func(this *EvalJade) reflect.Value {
+		fn := this.boundFunction(node, token.Name)
+		event := this.beforeEvent("function", token.Name)
+		val1, err := this.callFunc(fn, token.Name, token.Arguments)
+		this.afterEvent(event, err)
+		if err != nil {
+			this.errorf(node, "External function %q Error: %v", token.Name, err)
+		}
+		return val1
+	}
+}
+
+// builtinFunction returns the builtin function or operator with the name.
+func builtinFunction(name string) (interface{}, bool) {
+	if fn, ok := builtin[name]; ok {
+		return fn, true
+	}
+	fn, ok := standardFunctions[name]
+	return fn, ok
+}
+
+// boundFunction returns the function called by name, found once per render, see findFunction.
+func (this *EvalJade) boundFunction(node *TreeNode, name string) reflect.Value {
+	if fn, ok := this.funcs[name]; ok {
+		return fn
+	}
+	fn := this.findFunction(node, name)
+	if this.funcs == nil {
+		this.funcs = make(map[string]reflect.Value)
+	}
+	this.funcs[name] = fn
+	return fn
+}
+
+func constantValue(value reflect.Value) valueFunc {
+	return func(this *EvalJade) reflect.Value {
+		return value
+	}
+}
+
+// planOperator binds a operator to its builtin function. Methods on the data cannot be
+// named after a operator, so the builtin function is always the function found.
+func planOperator(node *TreeNode, token *OperatorToken) valueFunc {
+	if token.Operator == "?" {
+		return func(this *EvalJade) reflect.Value {
+			return this.conditional(node)
+		}
+	}
+	fnvalue, ok := builtin[token.Operator]
+	if !ok {
+		return func(this *EvalJade) reflect.Value {
+			return this.evalOperator(node, token)
+		}
+	}
+	fn := reflect.ValueOf(fnvalue)
+	call := operatorCall(fnvalue, len(node.items))
+	return func(this *EvalJade) reflect.Value {
+		var val1 reflect.Value
+		var err error
+		if call != nil {
+			val1, err = this.callOperator(fn, call, token.Operator, node.items)
+		} else {
+			val1, err = this.callFunc(fn, token.Operator, node.items)
+		}
+		if err != nil {
+			this.errorf(node, "Error on operator %q Error: %v", token.Operator, err)
+		}
+		return val1
+	}
+}
+
+// operatorCall returns a function calling a builtin operator with interface{}
+// parameters directly, nil for functions called by reflection. The results is the
+// same values returned by reflection.
+func operatorCall(fnvalue interface{}, count int) func(args []interface{}) (reflect.Value, error) {
+	switch fn := fnvalue.(type) {
+	case func(interface{}, ...interface{}) bool:
+		if count > 0 {
+			return func(args []interface{}) (reflect.Value, error) {
+				return reflect.ValueOf(fn(args[0], args[1:]...)), nil
+			}
+		}
+	case func(interface{}, ...interface{}) (bool, error):
+		if count > 0 {
+			return func(args []interface{}) (reflect.Value, error) {
+				result, err := fn(args[0], args[1:]...)
+				return reflect.ValueOf(result), err
+			}
+		}
+	case func(interface{}, interface{}) (bool, error):
+		if count == 2 {
+			return func(args []interface{}) (reflect.Value, error) {
+				result, err := fn(args[0], args[1])
+				return reflect.ValueOf(result), err
+			}
+		}
+	case func(interface{}, ...interface{}) interface{}:
+		if count > 0 {
+			return func(args []interface{}) (reflect.Value, error) {
+				//reflection returns the interface{} result as a value of kind Interface.
+				result := fn(args[0], args[1:]...)
+				return reflect.ValueOf(&result).Elem(), nil
+			}
+		}
+	}
+	return nil
+}
+
+// callOperator calls a operator returned by operatorCall with the values of the items,
+// see callFunc. Values that cannot be used as a interface{}, like unexported fields,
+// is passed by reflection.
+func (this *EvalJade) callOperator(fn reflect.Value, call func(args []interface{}) (reflect.Value, error), name string, items []*TreeNode) (result reflect.Value, err error) {
+	defer errRecover(&err)
+	argv := make([]reflect.Value, len(items))
+	args := make([]interface{}, len(items))
+	exported := true
+	for i, item := range items {
+		argv[i] = this.Sandbox.value(this.getValue(item))
+		if !argv[i].IsValid() || argv[i].Type() == nilValueType {
+			//nil values is passed as a nil interface{}, see validateType.
+			argv[i] = reflect.Zero(fn.Type().In(0))
+		}
+		if exported = exported && argv[i].CanInterface(); exported {
+			argv[i] = toCommonType(argv[i])
+			args[i] = argv[i].Interface()
+		}
+	}
+	if exported {
+		result, err = call(args)
+	} else {
+		fnresult := fn.Call(argv)
+		result = fnresult[0]
+		if len(fnresult) == 2 && !fnresult[1].IsNil() {
+			err = fnresult[1].Interface().(error)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error calling %s: %w", name, err)
+	}
+	return
+}
+
+// constantEscapeHtml returns the escaped text of a escapeHtml call with a constant argument.
+func constantEscapeHtml(fn *FuncToken) (string, bool) {
+	if fn.Name != escapeHtmlFunc || len(fn.Arguments) == 0 {
+		return "", false
+	}
+	if text, ok := constantText(fn.Arguments[0]); ok {
+		return html.EscapeString(text), true
+	}
+	return "", false
+}
+
+// constantText returns the text written for a text or number value.
+func constantText(node *TreeNode) (string, bool) {
+	switch val := node.Value.(type) {
+	case *TextToken:
+		return val.Text, true
+	case *NumberToken:
+		return ObjToString(val.Value), true
+	}
+	return "", false
+}
+
+// planContent returns the closure rendering the items of a node, see evalContent. The
+// steps of beautified output is compiled the first time the node is beautified.
+func planContent(node *TreeNode) execFunc {
+	plain := appendContent(make([]planOp, 0), node.items, false)
+	var beautified []planOp
+	var once sync.Once
+	return func(this *EvalJade) {
+		ops := plain
+		if this.Beautify {
+			once.Do(func() {
+				beautified = appendContent(make([]planOp, 0), node.items, true)
+			})
+			ops = beautified
+		}
+		var ifresult int
+		for i := range ops {
+			op := &ops[i]
+			this.checkContext()
+			if op.steps > 0 {
+				this.countSteps(op.node, op.steps)
+			}
+			if op.cond != nil {
+				fntoken := op.cond.Value.(*FuncToken)
+				if fntoken.Name != "else" {
+					ifresult = this.evalIfElse(op.cond, fntoken)
+				} else if ifresult == 2 {
+					if len(fntoken.Arguments) > 0 {
+						ifresult = this.evalIfElse(op.cond, fntoken)
+						continue
+					}
+					this.evalContent(op.cond)
+					ifresult = 0
+				}
+				continue
+			}
+			ifresult = 0
+			if op.exec != nil {
+				op.exec(this)
+			} else {
+				this.writeStatic(op)
+			}
+		}
+	}
+}
+
+// writeStatic writes the text of a plan step, and flushes the output after the closing tags in FlushTags.
+// Empty text is not written, writing sets if the beautified output ends with a new line.
+func (this *EvalJade) writeStatic(op *planOp) {
+	start := 0
+	newline := op.newline
+	if len(this.FlushTags) > 0 {
+		for _, close := range op.closes {
+			if InSlice(this.FlushTags, close.tag) {
+				this.writer.write(op.text[start:close.end])
+				//the new line after the closing tag is written before the output is flushed.
+				if newline && close.end == len(op.text) {
+					this.writer.beautifyNewLine()
+					newline = false
+				}
+				this.writer.flush()
+				start = close.end
+			}
+		}
+	}
+	if start < len(op.text) {
+		this.writer.write(op.text[start:])
+	}
+	if newline {
+		this.writer.beautifyNewLine()
+	}
+}
+
+// appendContent adds the steps rendering a list of items. The content of tags and
+// interpolated text is added to the same steps, with a empty text step before and
+// after the content ending any if statement, as when the content is rendered on its own.
+// Items written as static text is counted by the text step, see EvalJade.router.
+func appendContent(ops []planOp, items []*TreeNode, beautify bool) []planOp {
+	for _, item := range items {
+		item := item
+		switch val := item.Value.(type) {
+		case *FuncToken:
+			switch val.Name {
+			case "if", "unless", "else":
+				ops = append(ops, planOp{cond: item})
+			default:
+				ops = append(ops, planOp{exec: planWrite(item)})
+			}
+		case *TextToken:
+			ops = appendStep(ops, item)
+			ops = appendText(ops, val.Text)
+		case *NumberToken:
+			ops = appendStep(ops, item)
+			ops = appendText(ops, strconv.FormatFloat(val.Value, 0, 0, 64))
+		case *EmptyToken:
+			ops = appendStep(ops, item)
+			ops = appendContent(ops, item.items, beautify)
+			ops = appendText(ops, "")
+		case *HtmlTagToken:
+			ops = appendTag(ops, item, val, beautify)
+		default:
+			ops = append(ops, planOp{exec: func(this *EvalJade) {
+				this.router(item)
+			}})
+		}
+	}
+	return ops
+}
+
+// appendText adds static text, joining it with the previous text step.
+func appendText(ops []planOp, text string) []planOp {
+	if n := len(ops); n > 0 && ops[n-1].cond == nil && ops[n-1].exec == nil && !ops[n-1].newline {
+		ops[n-1].text += text
+		return ops
+	}
+	return append(ops, planOp{text: text})
+}
+
+// appendStep counts a node written by the following static text.
+func appendStep(ops []planOp, node *TreeNode) []planOp {
+	ops = appendText(ops, "")
+	last := &ops[len(ops)-1]
+	if last.steps == 0 {
+		last.node = node
+	}
+	last.steps++
+	return ops
+}
+
+// appendNewline adds a new line after the text written, see jadewriter.beautifyNewLine.
+func appendNewline(ops []planOp) []planOp {
+	ops = appendText(ops, "")
+	ops[len(ops)-1].newline = true
+	return ops
+}
+
+// appendTag adds the steps rendering a html tag, see jadewriter.HtmlTag.
+func appendTag(ops []planOp, node *TreeNode, tag *HtmlTagToken, beautify bool) []planOp {
+	indent := ""
+	if beautify && node.Depth() > 1 {
+		indent = strings.Repeat("  ", node.Depth()-1)
+	}
+	ops = appendStep(ops, node)
+	ops = appendText(ops, indent+"<"+tag.TagName)
+	for _, attr := range tag.Attributes {
+		attr := attr
+		if text, ok := constantAttribute(attr); ok {
+			//class values is routed, see jadewriter.classAttribute.
+			if strings.ToLower(attr.Value.(*KeyValueToken).Key) == "class" {
+				ops = appendStep(ops, attr.Value.(*KeyValueToken).Value)
+			}
+			ops = appendText(ops, text)
+			continue
+		}
+		ops = append(ops, planOp{exec: planRouted(node, func(this *EvalJade) {
+			this.writer.AttributeItem(attr)
+		})})
+	}
+	if tag.SelfClosing {
+		ops = append(ops, planOp{exec: func(this *EvalJade) {
+			if this.doctype == "html" {
+				this.writer.write(">")
+			} else {
+				this.writer.write("/>")
+			}
+		}})
+		if beautify {
+			ops = appendNewline(ops)
+		}
+		return ops
+	}
+	ops = appendText(ops, ">")
+	if beautify && len(node.items) > 1 {
+		ops = appendNewline(ops)
+	}
+	ops = appendContent(ops, node.items, beautify)
+	if beautify && len(node.items) > 1 {
+		ops = appendNewline(ops)
+	} else {
+		indent = ""
+	}
+	ops = appendText(ops, indent+"</"+tag.TagName+">")
+	last := &ops[len(ops)-1]
+	last.closes = append(last.closes, planClose{len(last.text), tag.TagName})
+	if beautify {
+		ops = appendNewline(ops)
+	}
+	return ops
+}
+
+// constantAttribute returns the text of a attribute with a text or number value.
+// Boolean attributes depend on the doctype and is never constant.
+func constantAttribute(node *TreeNode) (string, bool) {
+	keyvalue, ok := node.Value.(*KeyValueToken)
+	if !ok {
+		return "", false
+	}
+	valueNode, escape := stripEscapeHtml(keyvalue.Value)
+	text, ok := constantText(valueNode)
+	if !ok {
+		return "", false
+	}
+	switch strings.ToLower(keyvalue.Key) {
+	case "style", "class":
+		//style and class values is rendered by walking the value.
+		if _, ok := valueNode.Value.(*TextToken); !ok || strings.ToLower(keyvalue.Key) == "style" {
+			return "", false
+		}
+	default:
+		if escape {
+			text = html.EscapeString(text)
+		}
+	}
+	return " " + keyvalue.Key + "=\"" + text + "\"", true
+}
+
+// planWrite returns the closure writing the value of a function, see jadewriter.stdfunc.
+func planWrite(node *TreeNode) execFunc {
+	value := node.value
+	fn := node.Value.(*FuncToken)
+	if text, ok := constantEscapeHtml(fn); ok {
+		return func(this *EvalJade) {
+			this.step(node)
+			this.writer.write(text)
+		}
+	}
+	if fn.Name == escapeHtmlFunc && len(fn.Arguments) > 0 {
+		arg := fn.Arguments[0]
+		return planRouted(node, func(this *EvalJade) {
+			this.step(node)
+			this.writer.write(this.escapeHtml(arg))
+		})
+	}
+	return planRouted(node, func(this *EvalJade) {
+		this.step(node)
+		this.writer.write(this.valueText(value(this)))
+	})
+}
+
+// planRouted returns a closure executing fn with node as the node routed, to locate errors.
+func planRouted(node *TreeNode, fn execFunc) execFunc {
+	return func(this *EvalJade) {
+		//the previous node is not restored when a panic occurs, so errors are located at the inner most node.
+		prevnode := this.node
+		this.node = node
+		fn(this)
+		this.node = prevnode
+	}
+}
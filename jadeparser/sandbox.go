@@ -0,0 +1,209 @@
+package jadeparser
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// ErrNotAllowed is returned when a template uses a function, method or field
+// not allowed by the Sandbox. Use errors.Is(err, ErrNotAllowed) to test for it.
+var ErrNotAllowed = errors.New("Not allowed by the sandbox")
+
+// Sandbox restricts the registered functions, methods and fields a template can use,
+// for templates written by users that should not call methods like Delete() on the data.
+// Structs written to the output or passed to functions like json only contain the allowed
+// fields, and their methods like String and MarshalJSON is not called.
+// A nil Sandbox does not restrict anything.
+type Sandbox struct {
+	//Functions is the registered functions templates can call, every registered function
+	//can be called when Functions is nil. Builtin functions is always allowed.
+	Functions []string
+	//DisableMethods when true, templates cannot call methods on the data.
+	DisableMethods bool
+	//Members is the methods and fields templates can use on a type, other members of
+	//the type cannot be used. A pointer type uses the members of the type it points to.
+	//Example: Members: map[reflect.Type][]string{reflect.TypeOf(User{}): {"Name", "Email", "FullName"}}
+	Members map[reflect.Type][]string
+	//TagOptIn when true, methods of types not in Members cannot be called and struct fields
+	//of types not in Members can only be used when the field has a jade tag other than "-".
+	//Tags is ignored when TagOptIn is false.
+	//Example: with TagOptIn, Name string `jade:"name"` can be used, Password string `jade:"-"`
+	//and fields without a jade tag cannot.
+	TagOptIn bool
+}
+
+// AllowFunction reports if a registered function can be called.
+func (this *Sandbox) AllowFunction(name string) bool {
+	if this == nil || this.Functions == nil {
+		return true
+	}
+	return containsString(this.Functions, name)
+}
+
+// AllowMember reports if a method or field of a type can be used.
+func (this *Sandbox) AllowMember(typ reflect.Type, name string, method bool) bool {
+	if this == nil {
+		return true
+	}
+	if method && this.DisableMethods {
+		return false
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if members, ok := this.Members[typ]; ok {
+		return containsString(members, name)
+	}
+	if this.TagOptIn {
+		if method {
+			return false
+		}
+		if typ.Kind() == reflect.Struct {
+			field, ok := membersOf(typ).field(name)
+			tag := field.Tag.Get("jade")
+			return ok && len(tag) > 0 && tag != "-"
+		}
+	}
+	return true
+}
+
+// maxValueDepth is the depth of the values copied by Sandbox.value, values deeper than
+// maxValueDepth, like values referencing themselves, is nil.
+const maxValueDepth = 32
+
+// basicTypes is the types values of named types is converted to by Sandbox.value.
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:    reflect.TypeOf(false),
+	reflect.Int:     reflect.TypeOf(int(0)),
+	reflect.Int8:    reflect.TypeOf(int8(0)),
+	reflect.Int16:   reflect.TypeOf(int16(0)),
+	reflect.Int32:   reflect.TypeOf(int32(0)),
+	reflect.Int64:   reflect.TypeOf(int64(0)),
+	reflect.Uint:    reflect.TypeOf(uint(0)),
+	reflect.Uint8:   reflect.TypeOf(uint8(0)),
+	reflect.Uint16:  reflect.TypeOf(uint16(0)),
+	reflect.Uint32:  reflect.TypeOf(uint32(0)),
+	reflect.Uint64:  reflect.TypeOf(uint64(0)),
+	reflect.Float32: reflect.TypeOf(float32(0)),
+	reflect.Float64: reflect.TypeOf(float64(0)),
+	reflect.String:  reflect.TypeOf(""),
+}
+
+// value returns a copy of a value with only the fields the sandbox allows, for values
+// written to the output or passed to functions with interface{} parameters like json.
+// Structs and maps is copied to a LinearMap and values of named types is converted to
+// their basic type, so methods like String, Error and MarshalJSON is never called.
+// A nil Sandbox returns the value.
+func (this *Sandbox) value(val reflect.Value) reflect.Value {
+	if this == nil {
+		return val
+	}
+	return this.copyValue(val, 0)
+}
+
+func (this *Sandbox) copyValue(val reflect.Value, depth int) reflect.Value {
+	if !val.IsValid() || val.Type() == nilValueType {
+		return val
+	}
+	if depth > maxValueDepth || !val.CanInterface() {
+		return reflect.Value{}
+	}
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if val.IsNil() {
+			return reflect.Value{}
+		}
+		if val.Type() == LinearMapType {
+			src := val.Interface().(*LinearMap)
+			result := &LinearMap{make(map[string]interface{}), make([]string, 0)}
+			for _, key := range src.Keys() {
+				result.Set(key, this.interfaceOf(reflect.ValueOf(src.Get(key)), depth))
+			}
+			return reflect.ValueOf(result)
+		}
+		return this.copyValue(val.Elem(), depth+1)
+	case reflect.Struct:
+		typ := val.Type()
+		result := &LinearMap{make(map[string]interface{}), make([]string, 0)}
+		for _, field := range reflect.VisibleFields(typ) {
+			if field.Anonymous || !field.IsExported() || !this.AllowMember(typ, field.Name, false) {
+				continue
+			}
+			//fields of nil embedded pointers is skipped.
+			if fval, err := val.FieldByIndexErr(field.Index); err == nil {
+				result.Set(field.Name, this.interfaceOf(fval, depth))
+			}
+		}
+		return reflect.ValueOf(result)
+	case reflect.Map:
+		keys := make([]string, 0, val.Len())
+		values := make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			key := valueToString(this.copyValue(iter.Key(), depth+1))
+			keys = append(keys, key)
+			values[key] = this.interfaceOf(iter.Value(), depth)
+		}
+		sort.Strings(keys)
+		result := &LinearMap{make(map[string]interface{}), make([]string, 0)}
+		for _, key := range keys {
+			result.Set(key, values[key])
+		}
+		return reflect.ValueOf(result)
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, val.Len())
+		for i := range result {
+			result[i] = this.interfaceOf(val.Index(i), depth)
+		}
+		return reflect.ValueOf(result)
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return reflect.Value{}
+	}
+	if typ, ok := basicTypes[val.Kind()]; ok && val.Type() != typ {
+		return val.Convert(typ)
+	}
+	return val
+}
+
+// interfaceOf returns the copy of a member of a value as a interface{}.
+func (this *Sandbox) interfaceOf(val reflect.Value, depth int) interface{} {
+	val = this.copyValue(val, depth+1)
+	if !val.IsValid() {
+		return nil
+	}
+	return val.Interface()
+}
+
+// valueText returns the text of a value written to the output, see Sandbox.value.
+func (this *EvalJade) valueText(val reflect.Value) string {
+	return valueToString(this.Sandbox.value(val))
+}
+
+// checkFunction terminates processing when the sandbox does not allow the registered function.
+func (this *EvalJade) checkFunction(node *TreeNode, name string) {
+	if !this.Sandbox.AllowFunction(name) {
+		this.errorf(node, "%v: function %q.", ErrNotAllowed, name)
+	}
+}
+
+// checkMember terminates processing when the sandbox does not allow the method or field of a value.
+func (this *EvalJade) checkMember(node *TreeNode, value reflect.Value, name string, method bool) {
+	if this.Sandbox.AllowMember(value.Type(), name, method) {
+		return
+	}
+	kind := "field"
+	if method {
+		kind = "method"
+	}
+	this.errorf(node, "%v: %s %q on %s.", ErrNotAllowed, kind, name, value.Type())
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
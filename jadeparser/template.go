@@ -1,59 +1,279 @@
 package jadeparser
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Template struct {
-	Name   string
-	File   []byte
-	Root   *ParseResult
-	IsJade bool
+	Name    string
+	File    []byte
+	Root    *ParseResult
+	IsJade  bool
+	Path    string    //Path of the file the template was loaded from.
+	Layer   int       //Index of the view path the template was loaded from, see LayerLoader.
+	ModTime time.Time //Modification time of the file when loaded, zero if not known.
 }
 
 type jadePart struct {
-	Name string
-	Part *TreeNode
-	File []byte
+	Name  string
+	Part  *TreeNode
+	File  []byte
+	Layer int
 }
 
 type TemplateLoader interface {
 	SetViewPath(string)
-	Load(string) *Template
+	Load(string) (*Template, error)
 }
 
-//Default Template Loader
+// ModTimeLoader is implemented by loaders that can report when a template was last modified.
+type ModTimeLoader interface {
+	ModTime(name string) (time.Time, error)
+}
+
+// ListLoader is implemented by loaders that can list the jade templates they contain.
+type ListLoader interface {
+	//List returns the names of all jade templates, sorted by name.
+	List() ([]string, error)
+}
+
+// LayerLoader is implemented by loaders searching a ordered list of view paths,
+// called layers. A file in a layer overrides the same file in the layers after it.
+type LayerLoader interface {
+	TemplateLoader
+	//LoadLayer loads a template from the first layer containing the file, starting at layer.
+	LoadLayer(name string, layer int) (*Template, error)
+}
+
+// NextLayerPrefix prefixed to a extends or include file name, loads the file from the
+// layers after the layer of the current template. This allows a template to extend the
+// template it overrides. Example: extends ^layout.jade
+const NextLayerPrefix = "^"
+
+// loadTemplate loads a template for a template in layer.
+func loadTemplate(loader TemplateLoader, name string, layer int) (*Template, error) {
+	if !strings.HasPrefix(name, NextLayerPrefix) {
+		return loader.Load(name)
+	}
+	layers, ok := loader.(LayerLoader)
+	if !ok {
+		return nil, fmt.Errorf("Cannot load %q, the template loader does not support layers.", name)
+	}
+	return layers.LoadLayer(strings.TrimPrefix(name, NextLayerPrefix), layer+1)
+}
+
+// templateModTime returns the modification time of a template loaded by a template in layer.
+// ok is false if the loader cannot report modification times.
+func templateModTime(loader TemplateLoader, name string, layer int) (modtime time.Time, ok bool, err error) {
+	if strings.HasPrefix(name, NextLayerPrefix) {
+		if layers, isLayer := loader.(*layerLoader); isLayer {
+			modtime, err = layers.modTimeLayer(strings.TrimPrefix(name, NextLayerPrefix), layer+1)
+			return modtime, true, err
+		}
+		return
+	}
+	if mloader, isModTime := loader.(ModTimeLoader); isModTime {
+		modtime, err = mloader.ModTime(name)
+		return modtime, true, err
+	}
+	return
+}
+
+// templateKey returns the key a template loaded by a template in layer is stored under.
+// Templates loaded from the next layer is relative to the layer it is loaded from.
+func templateKey(name string, layer int) string {
+	if strings.HasPrefix(name, NextLayerPrefix) {
+		return fmt.Sprintf("%s@%v", name, layer)
+	}
+	return name
+}
+
+// layerLoader searches a list of loaders in order.
+type layerLoader struct {
+	loaders []TemplateLoader
+}
+
+// NewLayerLoader creates a loader searching each loader in turn. The first loader
+// containing the template is used.
+func NewLayerLoader(loaders ...TemplateLoader) LayerLoader {
+	return &layerLoader{loaders}
+}
+
+// SetViewPath is not supported by a layer loader, the view path is set on each layer.
+func (this *layerLoader) SetViewPath(path string) {
+}
+
+func (this *layerLoader) Load(name string) (*Template, error) {
+	return this.LoadLayer(name, 0)
+}
+
+// ModTime returns the modification time of the template in the first layer containing
+// the template. Layers not reporting modification times are skipped.
+func (this *layerLoader) ModTime(name string) (time.Time, error) {
+	return this.modTimeLayer(name, 0)
+}
+
+func (this *layerLoader) modTimeLayer(name string, layer int) (time.Time, error) {
+	var err error = &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	for i := layer; i < len(this.loaders); i++ {
+		var modtime time.Time
+		var ok bool
+		modtime, ok, err = templateModTime(this.loaders[i], name, 0)
+		if !ok || errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return modtime, err
+	}
+	return time.Time{}, err
+}
+
+// List returns the jade templates in all layers.
+func (this *layerLoader) List() ([]string, error) {
+	found := make(map[string]bool)
+	result := make([]string, 0)
+	for _, loader := range this.loaders {
+		list, ok := loader.(ListLoader)
+		if !ok {
+			continue
+		}
+		names, err := list.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !found[name] {
+				found[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (this *layerLoader) LoadLayer(name string, layer int) (*Template, error) {
+	var err error = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	for i := layer; i < len(this.loaders); i++ {
+		var template *Template
+		template, err = this.loaders[i].Load(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if template != nil {
+			template.Layer = i
+		}
+		return template, err
+	}
+	return nil, err
+}
+
+// Default Template Loader
 type templateLoader struct {
 	viewPath string
 }
 
+// NewTemplateLoader creates the default loader, loading templates from the view path on disk.
+func NewTemplateLoader(viewPath string) TemplateLoader {
+	return &templateLoader{viewPath}
+}
+
 func (this *templateLoader) SetViewPath(path string) {
 	this.viewPath = path
 }
 
-func (this *templateLoader) Load(name string) *Template {
+// Load loads and parses a template. A template that fails to parse is returned
+// together with the parse error.
+func (this *templateLoader) Load(name string) (*Template, error) {
 	filename, err := this.findfile(name)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
 	}
 	file, err := this.loadfile(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	template, err := newTemplate(name, filename, file)
+	template.ModTime = info.ModTime()
+	return template, err
+}
+
+// ModTime returns the modification time of the template file.
+func (this *templateLoader) ModTime(name string) (time.Time, error) {
+	filename, err := this.findfile(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// newTemplate creates a template from a loaded file, parsing the file if it is a jade file.
+func newTemplate(name string, filename string, file []byte) (*Template, error) {
 	template := new(Template)
 	template.Name = name
+	template.Path = filename
 	template.File = file
 
-	if this.isJadeFile(filename) {
+	if isJadeFile(filename) {
 		template.IsJade = true
-		template.Root = Parse(string(template.File))
-		return template
+		template.Root = parseTemplate(name, template.File)
+		return template, template.Root.Err
 	} else {
 		template.IsJade = false
-		return template
+		return template, nil
+	}
+}
+
+// List returns the jade templates in the view path.
+func (this *templateLoader) List() ([]string, error) {
+	root, err := filepath.Abs(this.viewPath)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	err = filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isJadeFile(filename) {
+			name, err := filepath.Rel(root, filename)
+			if err != nil {
+				return err
+			}
+			result = append(result, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	return result, err
+}
+
+// parseTemplate parses a template, naming the template in the parse error.
+func parseTemplate(name string, file []byte) *ParseResult {
+	result := Parse(string(file))
+	if err, ok := result.Err.(*TemplateError); ok {
+		err.Name = name
+	}
+	for _, warning := range result.Warnings {
+		warning.Name = name
 	}
+	return result
 }
 
 // floadfile Find and Load a file.
@@ -82,10 +302,175 @@ func (this *templateLoader) findfile(filename string) (string, error) {
 	return filename, nil
 }
 
-func (this *templateLoader) isJadeFile(filename string) bool {
+func isJadeFile(filename string) bool {
 	return strings.HasSuffix(filename, ".jade")
 }
 
 func (this *templateLoader) loadfile(filename string) ([]byte, error) {
 	return ioutil.ReadFile(filename)
 }
+
+// fsLoader loads templates from a fs.FS, for example a embed.FS.
+type fsLoader struct {
+	fsys     fs.FS
+	viewPath string
+}
+
+// NewFSLoader creates a loader, loading templates from the view path inside fsys.
+func NewFSLoader(fsys fs.FS, viewPath string) TemplateLoader {
+	return &fsLoader{fsys, viewPath}
+}
+
+func (this *fsLoader) SetViewPath(path string) {
+	this.viewPath = path
+}
+
+// Load loads and parses a template. A template that fails to parse is returned
+// together with the parse error.
+func (this *fsLoader) Load(name string) (*Template, error) {
+	filename, err := this.findfile(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(this.fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := fs.ReadFile(this.fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(name, filename, file)
+	template.ModTime = info.ModTime()
+	return template, err
+}
+
+// ModTime returns the modification time of the template file.
+func (this *fsLoader) ModTime(name string) (time.Time, error) {
+	filename, err := this.findfile(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := fs.Stat(this.fsys, filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// List returns the jade templates in the view path.
+func (this *fsLoader) List() ([]string, error) {
+	root := path.Clean(strings.TrimPrefix(this.viewPath, "/"))
+	if root == "" {
+		root = "."
+	}
+	result := make([]string, 0)
+	err := fs.WalkDir(this.fsys, root, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && isJadeFile(filename) {
+			if root != "." {
+				filename = strings.TrimPrefix(filename, root+"/")
+			}
+			result = append(result, filename)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// findfile returns the path of a template inside the file system.
+func (this *fsLoader) findfile(filename string) (string, error) {
+	filename = strings.Trim(filename, " ")
+	if !strings.ContainsRune(filename, '.') {
+		filename = filename + ".jade"
+	}
+	filename = path.Join(strings.TrimPrefix(this.viewPath, "/"), filename)
+	if !fs.ValidPath(filename) {
+		return "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrInvalid}
+	}
+	return filename, nil
+}
+
+// MapLoader loads templates stored in memory by name. Templates can be added
+// while templates are loaded from other goroutines.
+type MapLoader struct {
+	mu      sync.RWMutex
+	sources map[string][]byte
+	added   map[string]time.Time
+}
+
+// NewMapLoader creates a empty MapLoader.
+func NewMapLoader() *MapLoader {
+	return &MapLoader{sources: make(map[string][]byte), added: make(map[string]time.Time)}
+}
+
+// Add adds or replaces a named template. The same naming rules as files apply,
+// a name without an extension is a jade template.
+func (this *MapLoader) Add(name string, source string) {
+	filename := this.findfile(name)
+	this.mu.Lock()
+	this.sources[filename] = []byte(source)
+	this.added[filename] = time.Now()
+	this.mu.Unlock()
+}
+
+// Len returns the number of templates in the loader.
+func (this *MapLoader) Len() int {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return len(this.sources)
+}
+
+// SetViewPath is not used by a MapLoader.
+func (this *MapLoader) SetViewPath(path string) {
+}
+
+func (this *MapLoader) Load(name string) (*Template, error) {
+	filename := this.findfile(name)
+	this.mu.RLock()
+	file, ok := this.sources[filename]
+	added := this.added[filename]
+	this.mu.RUnlock()
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+	template, err := newTemplate(name, filename, file)
+	template.ModTime = added
+	return template, err
+}
+
+// ModTime returns the time the template was added.
+func (this *MapLoader) ModTime(name string) (time.Time, error) {
+	filename := this.findfile(name)
+	this.mu.RLock()
+	added, ok := this.added[filename]
+	this.mu.RUnlock()
+	if !ok {
+		return time.Time{}, &fs.PathError{Op: "stat", Path: filename, Err: fs.ErrNotExist}
+	}
+	return added, nil
+}
+
+// List returns the jade templates in the loader.
+func (this *MapLoader) List() ([]string, error) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	result := make([]string, 0, len(this.sources))
+	for name := range this.sources {
+		if isJadeFile(name) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (this *MapLoader) findfile(filename string) string {
+	filename = strings.TrimPrefix(strings.Trim(filename, " "), "./")
+	if !strings.ContainsRune(filename, '.') {
+		filename = filename + ".jade"
+	}
+	return filename
+}
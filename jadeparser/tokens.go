@@ -51,7 +51,7 @@ type ErrorToken struct {
 }
 
 func NewErrorToken(err string) *ErrorToken {
-	return &ErrorToken{EmptyToken{CatOther, fmt.Errorf(err)}}
+	return &ErrorToken{EmptyToken{CatOther, fmt.Errorf("%s", err)}}
 }
 
 type NumberToken struct {
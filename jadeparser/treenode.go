@@ -10,11 +10,14 @@ type TreeNode struct {
 	parent *TreeNode
 	items  []*TreeNode
 	Pos    int
+	//content and value is the execution plan of the node, see compilePlan.
+	content execFunc
+	value   valueFunc
 }
 
 // NewTreeElement Creates a new TreeElement.
 func NewTreeNode(value Token) *TreeNode {
-	return &TreeNode{value, nil, make([]*TreeNode, 0), 0}
+	return &TreeNode{value, nil, make([]*TreeNode, 0), 0, nil, nil}
 }
 
 // Parent Returns the current element parent
@@ -0,0 +1,150 @@
+package jadeparser
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// typeCache contains the fields and methods found by name for each type used by
+// templates, so a field or method is only searched for once per type.
+// A *typeMembers is stored for each reflect.Type.
+var typeCache sync.Map
+
+var float64Type = reflect.TypeOf(float64(0))
+
+// typeMembers caches the fields and methods of a type found by name.
+type typeMembers struct {
+	typ     reflect.Type
+	fields  sync.Map //name to *cachedField
+	methods sync.Map //name to the index of the method, -1 when the type has no method with the name.
+}
+
+// cachedField is the result of finding a field by name.
+type cachedField struct {
+	field reflect.StructField
+	ok    bool
+}
+
+// membersOf returns the cached members of a type.
+func membersOf(typ reflect.Type) *typeMembers {
+	if members, ok := typeCache.Load(typ); ok {
+		return members.(*typeMembers)
+	}
+	members, _ := typeCache.LoadOrStore(typ, &typeMembers{typ: typ})
+	return members.(*typeMembers)
+}
+
+// field returns the field of a struct type by name, including fields promoted from
+// embedded structs, the same as reflect.Type.FieldByName.
+func (this *typeMembers) field(name string) (reflect.StructField, bool) {
+	if cached, ok := this.fields.Load(name); ok {
+		return cached.(*cachedField).field, cached.(*cachedField).ok
+	}
+	field, ok := this.typ.FieldByName(name)
+	this.fields.Store(name, &cachedField{field, ok})
+	return field, ok
+}
+
+// method returns the index of a method by name, the same as reflect.Type.MethodByName.
+func (this *typeMembers) method(name string) (int, bool) {
+	if index, ok := this.methods.Load(name); ok {
+		return index.(int), index.(int) >= 0
+	}
+	index := -1
+	if method, ok := this.typ.MethodByName(name); ok {
+		index = method.Index
+	}
+	this.methods.Store(name, index)
+	return index, index >= 0
+}
+
+// memberCache caches the field or method of a identity in a execution plan for the
+// last type seen, so a identity rendered in a loop finds its member without a lookup.
+// The member of the next identity in the chain is cached in next. A nil *memberCache
+// finds members in the typeCache.
+type memberCache struct {
+	last atomic.Value //*cachedMember
+	next *memberCache
+}
+
+// cachedMember is the field or method index found on a type.
+type cachedMember struct {
+	typ    reflect.Type
+	field  reflect.StructField
+	method int
+	ok     bool
+}
+
+// newMemberCache returns the caches of a chain of identities.
+func newMemberCache(identity *FuncToken) *memberCache {
+	if identity == nil {
+		return nil
+	}
+	return &memberCache{next: newMemberCache(identity.Next)}
+}
+
+// nextCache returns the cache of the next identity in the chain.
+func (this *memberCache) nextCache() *memberCache {
+	if this == nil {
+		return nil
+	}
+	return this.next
+}
+
+// field returns the field of a struct type by name, see typeMembers.field.
+func (this *memberCache) field(typ reflect.Type, name string) (reflect.StructField, bool) {
+	if this == nil {
+		return membersOf(typ).field(name)
+	}
+	if last, ok := this.last.Load().(*cachedMember); ok && last.typ == typ {
+		return last.field, last.ok
+	}
+	field, ok := membersOf(typ).field(name)
+	this.last.Store(&cachedMember{typ: typ, field: field, ok: ok})
+	return field, ok
+}
+
+// method returns the index of a method by name, see typeMembers.method.
+func (this *memberCache) method(typ reflect.Type, name string) (int, bool) {
+	if this == nil {
+		return membersOf(typ).method(name)
+	}
+	if last, ok := this.last.Load().(*cachedMember); ok && last.typ == typ {
+		return last.method, last.ok
+	}
+	index, ok := membersOf(typ).method(name)
+	this.last.Store(&cachedMember{typ: typ, method: index, ok: ok})
+	return index, ok
+}
+
+// fieldByName returns the field of a struct value by name. The error is a VariableNotDefined
+// when the struct has no field with the name, or the field is promoted through a nil embedded pointer.
+func fieldByName(rval reflect.Value, name string, cache *memberCache) (reflect.Value, error) {
+	field, ok := cache.field(rval.Type(), name)
+	if !ok {
+		return reflect.Value{}, VariableNotDefined{fmt.Errorf("Variable %q not defined on struct.", name)}
+	}
+	if len(field.Index) == 1 {
+		return rval.Field(field.Index[0]), nil
+	}
+	result, err := rval.FieldByIndexErr(field.Index)
+	if err != nil {
+		return reflect.Value{}, VariableNotDefined{fmt.Errorf("Variable %q not defined, the embedded struct is nil.", name)}
+	}
+	return result, nil
+}
+
+// methodByName returns the method of a value by name, the same as reflect.Value.MethodByName.
+// The result is not valid when the value has no method with the name.
+func methodByName(rval reflect.Value, name string, cache *memberCache) reflect.Value {
+	if !rval.IsValid() {
+		return reflect.Value{}
+	}
+	index, ok := cache.method(rval.Type(), name)
+	if !ok {
+		return reflect.Value{}
+	}
+	return rval.Method(index)
+}
@@ -0,0 +1,160 @@
+// Code generated by gojade generate from index.jade. DO NOT EDIT.
+
+package views
+
+import (
+	"bufio"
+	"html"
+	"io"
+
+	"github.com/zdebeer99/gojade/jadeparser"
+)
+
+// RenderIndex renders index.jade.
+func RenderIndex(wr io.Writer, data *Page) (err error) {
+	var at jadeparser.Location
+	defer jadeparser.RecoverError(&err, &at)
+	w := bufio.NewWriter(wr)
+	if data == nil {
+		data = new(Page)
+	}
+	var mixin2 func(entry_ Item, index_ int, attributes_ *jadeparser.LinearMap, block_ func())
+	mixin2 = func(entry_ Item, index_ int, attributes_ *jadeparser.LinearMap, block_ func()) {
+		if attributes_ == nil {
+			attributes_ = jadeparser.NewAttributes()
+		}
+		w.WriteString("<li class=\"")
+		at.Set("index.jade", 4, 24)
+		w.WriteString(html.EscapeString(func() string {
+			if float64(index_) == 0 {
+				return "first"
+			}
+			return ""
+		}()))
+		w.WriteString("\"")
+		at.Set("index.jade", 4, 60)
+		if attr3 := jadeparser.CallBuiltin("explodeAttributes", attributes_).(string); len(attr3) > 0 {
+			w.WriteString(" " + attr3)
+		}
+		w.WriteString("><a")
+		at.Set("index.jade", 5, 12)
+		w.WriteString(jadeparser.AttributeText("href", entry_.Link, true, "html"))
+		w.WriteString(">")
+		at.Set("index.jade", 5, 25)
+		w.WriteString(html.EscapeString(entry_.Name))
+		w.WriteString("</a>")
+		at.Set("index.jade", 6, 5)
+		if block_ != nil {
+			if block_ != nil {
+				block_()
+			}
+		}
+		w.WriteString("</li>")
+	}
+	w.WriteString("<!DOCTYPE html><html><head><title>")
+	at.Set("layout", 4, 12)
+	w.WriteString(html.EscapeString(data.Title))
+	w.WriteString("</title></head><body><h1 class=\"title\">Hello ")
+	at.Set("index.jade", 10, 20)
+	w.WriteString(html.EscapeString(func() string {
+		ptr1 := data.User
+		if ptr1 == nil {
+			return *new(string)
+		}
+		return ptr1.Name
+	}()))
+	w.WriteString("!</h1>")
+	at.Set("index.jade", 11, 31)
+	shop_ := (data.Title + " shop")
+	_ = shop_
+	w.WriteString("<ul>")
+	at.Set("index.jade", 13, 5)
+	for i_, entry_ := range data.Items {
+		_ = entry_
+		_ = i_
+		at.Set("index.jade", 14, 7)
+		mixin2(entry_, i_, jadeparser.NewAttributes("price", entry_.Price), func() {
+			w.WriteString("<span>")
+			at.Set("index.jade", 15, 15)
+			w.WriteString(html.EscapeString(jadeparser.CallBuiltin("format", "%.2f", entry_.Price).(string)))
+			w.WriteString("</span>")
+		})
+	}
+	w.WriteString("</ul><p>")
+	at.Set("index.jade", 16, 6)
+	w.WriteString(html.EscapeString(shop_))
+	w.WriteString("</p>")
+	at.Set("index.jade", 17, 3)
+	if func() bool {
+		ptr4 := data.User
+		if ptr4 == nil {
+			return *new(bool)
+		}
+		return ptr4.Admin
+	}() {
+		w.WriteString("<p class=\"admin\">Administrator</p>")
+	} else if at.Set("index.jade", 19, 3) && (float64(len(data.Items)) > 2) {
+		w.WriteString("<p>Many items</p>")
+	} else {
+		w.WriteString("<p>Few items</p>")
+	}
+	at.Set("index.jade", 23, 3)
+	case5 := data.Count
+	_ = case5
+	switch {
+	case at.Set("index.jade", 24, 5) && (float64(case5) == 0):
+		w.WriteString("<p>none</p>")
+	case at.Set("index.jade", 26, 5) && (float64(case5) == 1), at.Set("index.jade", 27, 5) && (float64(case5) == 2):
+		w.WriteString("<p>some</p>")
+	default:
+		w.WriteString("<p>many</p>")
+	}
+	at.Set("index.jade", 31, 3)
+	if data.User != nil {
+		w.WriteString("<input type=\"checkbox\"")
+		at.Set("index.jade", 32, 36)
+		w.WriteString(jadeparser.AttributeText("checked", func() bool {
+			ptr6 := data.User
+			if ptr6 == nil {
+				return *new(bool)
+			}
+			return ptr6.Admin
+		}(), true, "html"))
+		at.Set("index.jade", 32, 53)
+		w.WriteString(jadeparser.AttributeText("name", func() string {
+			ptr7 := data.User
+			if ptr7 == nil {
+				return *new(string)
+			}
+			return ptr7.Name
+		}(), true, "html"))
+		w.WriteString(">")
+	}
+	w.WriteString("<p>")
+	at.Set("index.jade", 33, 7)
+	w.WriteString(data.User.Greeting("Welcome"))
+	w.WriteString("</p><p class=\"site\">")
+	at.Set("index.jade", 34, 11)
+	w.WriteString(html.EscapeString(func() string {
+		ptr8 := data.Site
+		if ptr8 == nil {
+			return *new(string)
+		}
+		return ptr8.SiteName
+	}()))
+	w.WriteString("</p><p>Total: ")
+	at.Set("index.jade", 35, 14)
+	w.WriteString(jadeparser.EscapeText(func() float64 {
+		result, err := data.Total()
+		jadeparser.CheckError("Total", err)
+		return result
+	}()))
+	w.WriteString("</p><footer><p class=\"small\">Items: ")
+	at.Set("_footer", 2, 20)
+	w.WriteString(jadeparser.EscapeText(len(data.Items)))
+	w.WriteString("</p></footer></body></html>")
+	if err := w.Flush(); err != nil {
+		return &jadeparser.WriteError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package views is the data of the templates in res/generate, and the Go source generated
+// for them by TestGenerate.
+package views
+
+import "errors"
+
+type Page struct {
+	*Site
+	Title string
+	Count int
+	User  *User
+	Items []Item
+}
+
+// Total returns the total price of the items, a negative Count is a error.
+func (this *Page) Total() (float64, error) {
+	if this.Count < 0 {
+		return 0, errors.New("negative count")
+	}
+	total := 0.0
+	for _, item := range this.Items {
+		total += item.Price
+	}
+	return total, nil
+}
+
+type Site struct {
+	SiteName string
+}
+
+type User struct {
+	Name  string
+	Admin bool
+}
+
+func (this *User) Greeting(text string) string {
+	if this == nil {
+		return text
+	}
+	return text + " " + this.Name
+}
+
+type Item struct {
+	Name  string
+	Link  string
+	Price float64
+}
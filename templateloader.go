@@ -1,19 +1,75 @@
 package gojade
 
 import (
-	"io/ioutil"
+	"io/fs"
+	"reflect"
+	"strings"
 
 	"github.com/zdebeer99/gojade/jadeparser"
 )
 
-type templateLoader struct {
-	ViewPath string
+// loader creates the template loader for the engine's view paths. Templates are
+// loaded from the FS when set, otherwise from disk. Templates added with AddTemplate
+// is loaded before the view paths.
+func (this *Engine) loader() jadeparser.TemplateLoader {
+	if len(this.ViewPaths) == 0 && this.sources.Len() == 0 {
+		return this.pathLoader(this.ViewPath)
+	}
+	layers := make([]jadeparser.TemplateLoader, 0, len(this.ViewPaths)+1)
+	if this.sources.Len() > 0 {
+		layers = append(layers, this.sources)
+	}
+	if len(this.ViewPaths) == 0 {
+		layers = append(layers, this.pathLoader(this.ViewPath))
+	}
+	for _, path := range this.ViewPaths {
+		layers = append(layers, this.pathLoader(path))
+	}
+	return jadeparser.NewLayerLoader(layers...)
+}
+
+func (this *Engine) pathLoader(path string) jadeparser.TemplateLoader {
+	if this.FS != nil {
+		return jadeparser.NewFSLoader(this.FS, path)
+	}
+	return jadeparser.NewTemplateLoader(path)
+}
+
+// cacheKey identifies the view paths and FS the cached templates is compiled from.
+type cacheKey struct {
+	paths string
+	fs    interface{}
+}
+
+// loaderKey returns the cacheKey of the engine's current view paths and FS.
+func (this *Engine) loaderKey() cacheKey {
+	return cacheKey{this.ViewPath + "|" + strings.Join(this.ViewPaths, "|"), fsIdentity(this.FS)}
+}
+
+// fsIdentity returns a comparable value identifying fsys. Maps, pointers and other
+// reference types is identified by their address, as a fstest.MapFS cannot be compared.
+// A FS that cannot be compared otherwise is identified by its type only.
+func fsIdentity(fsys fs.FS) interface{} {
+	if fsys == nil {
+		return nil
+	}
+	val := reflect.ValueOf(fsys)
+	switch val.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return [2]interface{}{val.Type(), val.Pointer()}
+	}
+	if val.Comparable() {
+		return fsys
+	}
+	return val.Type()
 }
 
-func (this *templateLoader) Load(name string) *jadeparser.Template {
-	b, err := ioutil.ReadFile(name)
-	if err != nil {
-		panic(err)
+// templateName returns the name of a template file the way it is listed, to compare template names.
+func templateName(name string) string {
+	name = strings.TrimPrefix(strings.Trim(name, " "), "./")
+	name = strings.TrimPrefix(name, jadeparser.NextLayerPrefix)
+	if !strings.ContainsRune(name, '.') {
+		name = name + ".jade"
 	}
-	return &jadeparser.Template{File: b}
+	return name
 }